@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scjtqs2/bot_adapter/pb/entity"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// friendCacheTTL 好友列表的缓存时间
+const friendCacheTTL = 5 * time.Minute
+
+// friendCache 缓存好友列表，避免每条私聊都请求 bot_adapter
+type friendCache struct {
+	lock    sync.Mutex
+	ids     map[int64]bool
+	updated time.Time
+}
+
+var friends = &friendCache{}
+
+// invalidate 新增好友后让缓存失效
+func (f *friendCache) invalidate() {
+	f.lock.Lock()
+	f.updated = time.Time{}
+	f.lock.Unlock()
+}
+
+// isFriend 用户是否为好友，获取好友列表失败时使用上一次的结果
+func (f *friendCache) isFriend(ctx context.Context, userID int64) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if time.Since(f.updated) > friendCacheTTL {
+		c, cancel := context.WithTimeout(ctx, 5*time.Second)
+		rsp, err := botAdapterClient.GetFriendList(c, &entity.GetFriendListReq{})
+		cancel()
+		if err != nil {
+			log.Errorf("获取好友列表失败 err:%v", err)
+		} else {
+			f.ids = make(map[int64]bool, len(rsp.List))
+			for _, friend := range rsp.List {
+				f.ids[friend.UserId] = true
+			}
+			f.updated = time.Now()
+		}
+	}
+	return f.ids[userID]
+}
+
+// accessAllowed 按黑白名单和私聊好友策略判断是否处理消息，超级管理员不受限制
+// groupID 为 0 表示私聊
+func accessAllowed(ctx context.Context, groupID, userID int64) bool {
+	if bot.IsSuperuser(userID) {
+		return true
+	}
+	if groupID != 0 {
+		if !bot.AccessAllowed(bot.AccessGroup, groupID) {
+			return false
+		}
+	} else if bot.FriendsOnly() && !friends.isFriend(ctx, userID) {
+		return false
+	}
+	return bot.AccessAllowed(bot.AccessUser, userID)
+}
+
+// accessUsage #admin access 的详细用法
+const accessUsage = `#admin access 查看访问控制
+#admin access <user|group> mode <black|white> 使用黑名单或白名单
+#admin access <user|group> <black|white> [add|del <号码>] 查看或修改名单
+#admin access friends <on|off> 私聊是否只响应好友`
+
+// adminAccess 查看或修改用户和群的黑白名单
+func adminAccess(c *commandContext, args []string) {
+	if !c.allow("admin access", bot.RoleSuperuser) {
+		return
+	}
+	if len(args) == 0 {
+		c.reply(formatAccess())
+		return
+	}
+	target := strings.ToLower(args[0])
+	switch {
+	case target == "friends" && len(args) == 2:
+		on := strings.ToLower(args[1]) == "on"
+		if err := bot.SetFriendsOnly(on); err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		friends.invalidate()
+		if on {
+			c.reply("私聊只响应好友")
+		} else {
+			c.reply("私聊响应所有人")
+		}
+	case len(args) == 3 && strings.ToLower(args[1]) == "mode":
+		if err := bot.SetAccessMode(target, strings.ToLower(args[2])); err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		c.reply(formatAccess())
+	case len(args) == 2:
+		ids := bot.AccessList(target, strings.ToLower(args[1]))
+		list := make([]string, 0, len(ids))
+		for _, id := range ids {
+			list = append(list, strconv.FormatInt(id, 10))
+		}
+		if len(list) == 0 {
+			c.reply("名单为空")
+			return
+		}
+		c.reply(strings.Join(list, "、"))
+	case len(args) == 4:
+		id, err := strconv.ParseInt(args[3], 10, 64)
+		if err != nil {
+			c.replyf("无效的号码：%s", args[3])
+			return
+		}
+		mode := strings.ToLower(args[1])
+		switch strings.ToLower(args[2]) {
+		case "add":
+			err = bot.AddAccessList(target, mode, id)
+		case "del":
+			err = bot.RemoveAccessList(target, mode, id)
+		default:
+			c.reply(accessUsage)
+			return
+		}
+		if err != nil {
+			c.replyf("修改失败：%v", err)
+			return
+		}
+		c.reply("已更新名单")
+	default:
+		c.reply(accessUsage)
+	}
+}
+
+// formatAccess 当前的访问控制设置
+func formatAccess() string {
+	modeName := func(target string) string {
+		mode := bot.AccessMode(target)
+		name := "黑名单"
+		if mode == bot.AccessWhitelist {
+			name = "白名单"
+		}
+		return name + "（" + strconv.Itoa(len(bot.AccessList(target, mode))) + " 个）"
+	}
+	friendsOnly := "否"
+	if bot.FriendsOnly() {
+		friendsOnly = "是"
+	}
+	return "用户：" + modeName(bot.AccessUser) + "\n群：" + modeName(bot.AccessGroup) + "\n私聊只响应好友：" + friendsOnly
+}
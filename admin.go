@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+func init() {
+	registerCommand(&command{
+		name:   "admin",
+		usage:  "#admin [on|off|provider|trigger|reply|context|grouplog|stats|allow|access] 管理机器人，#admin help 查看详细用法",
+		perm:   bot.RoleGroupAdmin,
+		handle: adminCommand,
+	})
+}
+
+// adminUsage #admin 的详细用法
+const adminUsage = `#admin on [群号] 在群里启用机器人
+#admin off [群号] 在群里停用机器人，停用后只响应管理员的命令
+#admin provider [群号] <provider,...|reset> 设置群的提供者顺序
+#admin trigger [群号] 查看或修改群的触发规则，#admin trigger help 查看详细用法
+#admin reply [群号] <at|quote|both|reset> 设置回复时 @ 提问者、引用提问的消息或者两者都用
+#admin context [群号] <shared|user|reset> 群成员共享同一份历史消息，或者每人单独的历史消息
+#admin grouplog [群号] <on|off> 记录群里没有触发机器人的消息，触发时作为上下文，关闭时删除已有记录
+#admin stats [群号] 查看调用统计
+#admin allow <白名单> [add|del <QQ>] 查看或修改白名单（仅超级管理员）
+#admin access 查看或修改用户和群的黑白名单（仅超级管理员），#admin access help 查看详细用法
+指定其他群的群号需要超级管理员权限`
+
+// adminCommand 群聊中管理当前群，超级管理员可以在任意对话中指定群号管理其他群
+func adminCommand(c *commandContext) {
+	sub := "help"
+	if len(c.args) > 0 {
+		sub = strings.ToLower(c.args[0])
+	}
+	args := c.args
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	switch sub {
+	case "on", "off":
+		groupID, _, ok := c.adminGroup(sub, args)
+		if !ok {
+			return
+		}
+		if err := bot.SetGroupEnabled(groupID, sub == "on"); err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		if sub == "on" {
+			c.replyf("已在群 %d 启用机器人", groupID)
+		} else {
+			c.replyf("已在群 %d 停用机器人", groupID)
+		}
+	case "provider":
+		groupID, args, ok := c.adminGroup(sub, args)
+		if !ok {
+			return
+		}
+		if len(args) == 0 {
+			c.replyf("群 %d 的提供者顺序：%s", groupID, chainNames(bot.Route(groupID, 0)))
+			return
+		}
+		var chain []string
+		if strings.ToLower(args[0]) != "reset" {
+			chain = bot.ParseChain(strings.Join(args, ","))
+		}
+		if err := bot.SetGroupRoute(groupID, chain); err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		c.replyf("已设置群 %d 的提供者顺序：%s", groupID, chainNames(bot.Route(groupID, 0)))
+	case "trigger":
+		adminTrigger(c, args)
+	case "reply":
+		groupID, args, ok := c.adminGroup(sub, args)
+		if !ok {
+			return
+		}
+		if len(args) == 0 {
+			c.replyf("群 %d 的回复方式：%s", groupID, bot.GroupReplyMode(groupID))
+			return
+		}
+		mode := strings.ToLower(args[0])
+		if mode == "reset" {
+			mode = ""
+		}
+		if err := bot.SetGroupReplyMode(groupID, mode); err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		c.replyf("已设置群 %d 的回复方式：%s", groupID, bot.GroupReplyMode(groupID))
+	case "context":
+		groupID, args, ok := c.adminGroup(sub, args)
+		if !ok {
+			return
+		}
+		if len(args) > 0 {
+			var shared *bool
+			on, off := true, false
+			switch strings.ToLower(args[0]) {
+			case "shared":
+				shared = &on
+			case "user":
+				shared = &off
+			case "reset":
+			default:
+				c.reply("#admin context [群号] <shared|user|reset>")
+				return
+			}
+			if err := bot.SetGroupSharedContext(groupID, shared); err != nil {
+				c.replyf("设置失败：%v", err)
+				return
+			}
+		}
+		if bot.GroupSharedContext(groupID) {
+			c.replyf("群 %d 的成员共享同一份历史消息", groupID)
+		} else {
+			c.replyf("群 %d 的成员各自使用单独的历史消息", groupID)
+		}
+	case "grouplog":
+		groupID, args, ok := c.adminGroup(sub, args)
+		if !ok {
+			return
+		}
+		if len(args) > 0 {
+			on := strings.ToLower(args[0])
+			if on != "on" && on != "off" {
+				c.reply("#admin grouplog [群号] <on|off>")
+				return
+			}
+			if err := bot.SetGroupLogEnabled(groupID, on == "on"); err != nil {
+				c.replyf("设置失败：%v", err)
+				return
+			}
+		}
+		if bot.GroupLogEnabled(groupID) {
+			c.replyf("群 %d 已开启聊天记录，当前有 %d 条可以作为上下文的消息", groupID, len(bot.RecentGroupLog(groupID)))
+		} else {
+			c.replyf("群 %d 未开启聊天记录", groupID)
+		}
+	case "stats":
+		adminStats(c, args)
+	case "allow":
+		adminAllow(c, args)
+	case "access":
+		adminAccess(c, args)
+	default:
+		c.reply(adminUsage)
+	}
+}
+
+// adminGroup 解析要管理的群号，未指定时为当前群，指定其他群需要超级管理员权限
+func (c *commandContext) adminGroup(sub string, args []string) (int64, []string, bool) {
+	groupID := c.conv.GroupID
+	if len(args) > 0 {
+		if id, err := strconv.ParseInt(args[0], 10, 64); err == nil {
+			groupID = id
+			args = args[1:]
+		}
+	}
+	if groupID == 0 {
+		c.replyf("私聊中需要指定群号：#admin %s <群号>", sub)
+		return 0, nil, false
+	}
+	if groupID != c.conv.GroupID && !c.allow("admin "+sub+" <群号>", bot.RoleSuperuser) {
+		return 0, nil, false
+	}
+	return groupID, args, true
+}
+
+// adminStats 群聊中查看本群的消息数，超级管理员还可以查看提供者的调用次数
+func adminStats(c *commandContext, args []string) {
+	var b strings.Builder
+	if c.conv.IsGroup || len(args) > 0 {
+		groupID, _, ok := c.adminGroup("stats", args)
+		if !ok {
+			return
+		}
+		fmt.Fprintf(&b, "群 %d 处理的消息：%d", groupID, bot.Stat(fmt.Sprintf("group/%d/messages", groupID)))
+	}
+	if c.role >= bot.RoleSuperuser {
+		stats := bot.Stats("provider/")
+		names := make([]string, 0, len(stats))
+		for name := range stats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("提供者调用次数：")
+		for _, name := range names {
+			fmt.Fprintf(&b, "\n%s：%d", strings.TrimPrefix(name, "provider/"), stats[name])
+		}
+		if rejects := pushRejected.Values(); len(rejects) > 0 {
+			reasons := make([]string, 0, len(rejects))
+			for reason := range rejects {
+				reasons = append(reasons, reason)
+			}
+			sort.Strings(reasons)
+			b.WriteString("\n被拒绝的推送：")
+			for _, reason := range reasons {
+				label := strings.TrimSuffix(strings.TrimPrefix(reason, `reason="`), `"`)
+				fmt.Fprintf(&b, "\n%s：%.0f", label, rejects[reason])
+			}
+		}
+	}
+	if b.Len() == 0 {
+		c.reply("私聊中需要指定群号：#admin stats <群号>")
+		return
+	}
+	c.reply(b.String())
+}
+
+// adminAllow 查看或修改白名单
+func adminAllow(c *commandContext, args []string) {
+	if !c.allow("admin allow", bot.RoleSuperuser) {
+		return
+	}
+	if len(args) == 0 {
+		c.replyf("可用的白名单：%s", strings.Join(bot.AllowLists, "、"))
+		return
+	}
+	name := strings.ToLower(args[0])
+	if len(args) == 1 {
+		ids := bot.GetAllowList(name)
+		if len(ids) == 0 {
+			c.replyf("白名单 %s 为空", name)
+			return
+		}
+		list := make([]string, 0, len(ids))
+		for _, id := range ids {
+			list = append(list, strconv.FormatInt(id, 10))
+		}
+		c.replyf("白名单 %s：%s", name, strings.Join(list, "、"))
+		return
+	}
+	if len(args) < 3 {
+		c.reply("#admin allow <白名单> [add|del <QQ>]")
+		return
+	}
+	userID, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		c.replyf("无效的 QQ 号：%s", args[2])
+		return
+	}
+	switch strings.ToLower(args[1]) {
+	case "add":
+		err = bot.AddAllowList(name, userID)
+	case "del":
+		err = bot.RemoveAllowList(name, userID)
+	default:
+		c.reply("#admin allow <白名单> [add|del <QQ>]")
+		return
+	}
+	if err != nil {
+		c.replyf("修改失败：%v", err)
+		return
+	}
+	c.replyf("已更新白名单 %s", name)
+}
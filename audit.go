@@ -0,0 +1,91 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kataras/iris/v12"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// 审计接口单次最多返回的记录数
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// auditHandler 查询 AI 调用的审计记录，需要 Authorization: Bearer <audit.token>
+// 支持的参数：user_id、group_id、provider、since、until（RFC3339 或 unix 秒）、limit
+func auditHandler(ctx iris.Context) {
+	token := bot.Conf().Audit.Token
+	if token == "" {
+		_ = ctx.StopWithJSON(http.StatusForbidden, bot.MSG{"code": http.StatusForbidden, "msg": "audit api disabled"})
+		return
+	}
+	auth := strings.TrimPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+		_ = ctx.StopWithJSON(http.StatusUnauthorized, bot.MSG{"code": http.StatusUnauthorized, "msg": "unauthorized"})
+		return
+	}
+	f := bot.AuditFilter{
+		Provider: ctx.URLParam("provider"),
+		Limit:    auditDefaultLimit,
+	}
+	var errs []error
+	parseID := func(name string) int64 {
+		v := ctx.URLParam(name)
+		if v == "" {
+			return 0
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %q", name, v))
+		}
+		return n
+	}
+	parseTime := func(name string) time.Time {
+		v := ctx.URLParam(name)
+		if v == "" {
+			return time.Time{}
+		}
+		t, err := parseTimeParam(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid %s: %q", name, v))
+		}
+		return t
+	}
+	f.UserID = parseID("user_id")
+	f.GroupID = parseID("group_id")
+	f.Since = parseTime("since")
+	f.Until = parseTime("until")
+	if len(errs) > 0 {
+		_ = ctx.StopWithJSON(http.StatusBadRequest, bot.MSG{"code": http.StatusBadRequest, "msg": errors.Join(errs...).Error()})
+		return
+	}
+	if n, err := ctx.URLParamInt("limit"); err == nil && n > 0 {
+		f.Limit = n
+	}
+	if f.Limit > auditMaxLimit {
+		f.Limit = auditMaxLimit
+	}
+	entries := bot.QueryAudit(f)
+	_ = ctx.JSON(bot.MSG{
+		"code":    200,
+		"count":   len(entries),
+		"entries": entries,
+	})
+}
+
+// parseTimeParam 解析 RFC3339 或 unix 秒格式的时间
+func parseTimeParam(v string) (time.Time, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
@@ -0,0 +1,104 @@
+package bot
+
+import (
+	"fmt"
+	"slices"
+)
+
+// 访问控制的对象
+const (
+	AccessUser  = "user"
+	AccessGroup = "group"
+)
+
+// 访问控制的模式
+const (
+	AccessBlacklist = "black" // 默认，不响应黑名单中的用户或群
+	AccessWhitelist = "white" // 只响应白名单中的用户或群
+)
+
+func accessModeKey(target string) string {
+	return "@admin/access/" + target + "/mode"
+}
+
+func accessListKey(target, mode string) string {
+	return "@admin/access/" + target + "/" + mode
+}
+
+const friendsOnlyKey = "@admin/access/friends_only"
+
+func checkAccess(target, mode string) error {
+	if target != AccessUser && target != AccessGroup {
+		return fmt.Errorf("未知的对象: %s，可选 %s、%s", target, AccessUser, AccessGroup)
+	}
+	if mode != AccessBlacklist && mode != AccessWhitelist {
+		return fmt.Errorf("未知的模式: %s，可选 %s、%s", mode, AccessBlacklist, AccessWhitelist)
+	}
+	return nil
+}
+
+// AccessMode 用户或群当前使用的模式，默认为黑名单
+func AccessMode(target string) string {
+	buf, err := Msglog.db.Get([]byte(accessModeKey(target)), nil)
+	if err != nil || string(buf) != AccessWhitelist {
+		return AccessBlacklist
+	}
+	return AccessWhitelist
+}
+
+// SetAccessMode 设置用户或群使用黑名单还是白名单
+func SetAccessMode(target, mode string) error {
+	if err := checkAccess(target, mode); err != nil {
+		return err
+	}
+	return Msglog.db.Put([]byte(accessModeKey(target)), []byte(mode), nil)
+}
+
+// AccessList 获取用户或群的黑名单或白名单
+func AccessList(target, mode string) []int64 {
+	return getIDList(accessListKey(target, mode))
+}
+
+// AddAccessList 将号码加入黑名单或白名单
+func AddAccessList(target, mode string, id int64) error {
+	if err := checkAccess(target, mode); err != nil {
+		return err
+	}
+	return addIDList(accessListKey(target, mode), id)
+}
+
+// RemoveAccessList 将号码移出黑名单或白名单
+func RemoveAccessList(target, mode string, id int64) error {
+	if err := checkAccess(target, mode); err != nil {
+		return err
+	}
+	ok, err := removeIDList(accessListKey(target, mode), id)
+	if err == nil && !ok {
+		err = fmt.Errorf("%d 不在名单中", id)
+	}
+	return err
+}
+
+// AccessAllowed 按当前模式判断是否响应该用户或群
+func AccessAllowed(target string, id int64) bool {
+	mode := AccessMode(target)
+	listed := slices.Contains(AccessList(target, mode), id)
+	if mode == AccessWhitelist {
+		return listed
+	}
+	return !listed
+}
+
+// FriendsOnly 私聊是否只响应好友
+func FriendsOnly() bool {
+	ok, _ := Msglog.db.Has([]byte(friendsOnlyKey), nil)
+	return ok
+}
+
+// SetFriendsOnly 设置私聊是否只响应好友
+func SetFriendsOnly(on bool) error {
+	if !on {
+		return Msglog.db.Delete([]byte(friendsOnlyKey), nil)
+	}
+	return Msglog.db.Put([]byte(friendsOnlyKey), []byte("1"), nil)
+}
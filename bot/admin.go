@@ -0,0 +1,284 @@
+package bot
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Role 用户在当前对话中的权限等级，等级高的拥有等级低的全部权限
+type Role int
+
+// 权限等级
+const (
+	RoleUser       Role = iota // 普通用户
+	RoleGroupAdmin             // 群主或群管理员，私聊中用户是自己对话的管理员
+	RoleSuperuser              // 超级管理员，由配置 admin.superusers 或 #admin allow superuser 指定
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleSuperuser:
+		return "超级管理员"
+	case RoleGroupAdmin:
+		return "管理员"
+	}
+	return "普通用户"
+}
+
+// 群成员角色，对应推送中 sender.role 的取值
+const (
+	senderRoleOwner = "owner"
+	senderRoleAdmin = "admin"
+)
+
+// UserRole 计算发送者的权限等级，senderRole 为群聊推送中的 sender.role
+func UserRole(conv *Conversation, senderRole string) Role {
+	if IsSuperuser(conv.UserID) {
+		return RoleSuperuser
+	}
+	if !conv.IsGroup || senderRole == senderRoleOwner || senderRole == senderRoleAdmin {
+		return RoleGroupAdmin
+	}
+	return RoleUser
+}
+
+// IsSuperuser 是否为超级管理员
+func IsSuperuser(userID int64) bool {
+	for _, id := range Conf().Admin.Superusers {
+		if id == userID {
+			return true
+		}
+	}
+	return InAllowList(AllowListSuperuser, userID)
+}
+
+// Superusers 返回配置和白名单中的所有超级管理员
+func Superusers() []int64 {
+	ids := append([]int64(nil), Conf().Admin.Superusers...)
+	for _, id := range GetAllowList(AllowListSuperuser) {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// 内置的白名单
+const (
+	AllowListSuperuser = "superuser" // 通过命令添加的超级管理员
+	AllowListSMS       = "sms"       // 可以使用短信发送功能的用户
+)
+
+// AllowLists 可以通过 #admin 管理的白名单
+var AllowLists = []string{AllowListSuperuser, AllowListSMS}
+
+func allowListKey(name string) string {
+	return "@admin/allow/" + name
+}
+
+func groupDisabledKey(groupID int64) string {
+	return fmt.Sprintf("@admin/group/%d/disabled", groupID)
+}
+
+// GroupEnabled 机器人在群里是否启用，默认启用
+func GroupEnabled(groupID int64) bool {
+	ok, _ := Msglog.db.Has([]byte(groupDisabledKey(groupID)), nil)
+	return !ok
+}
+
+// SetGroupEnabled 在群里启用或停用机器人
+func SetGroupEnabled(groupID int64, enabled bool) error {
+	if enabled {
+		return Msglog.db.Delete([]byte(groupDisabledKey(groupID)), nil)
+	}
+	return Msglog.db.Put([]byte(groupDisabledKey(groupID)), []byte("1"), nil)
+}
+
+// 群聊中回复提问者的方式
+const (
+	ReplyModeAt    = "at"    // @ 提问者
+	ReplyModeQuote = "quote" // 引用提问的消息
+	ReplyModeBoth  = "both"  // 引用提问的消息并 @ 提问者
+)
+
+// ReplyModes 支持的回复方式
+var ReplyModes = []string{ReplyModeAt, ReplyModeQuote, ReplyModeBoth}
+
+func groupReplyModeKey(groupID int64) string {
+	return fmt.Sprintf("@admin/group/%d/reply_mode", groupID)
+}
+
+// GroupReplyMode 群的回复方式，没有单独设置时使用配置 chat.reply_mode
+func GroupReplyMode(groupID int64) string {
+	buf, err := Msglog.db.Get([]byte(groupReplyModeKey(groupID)), nil)
+	if err != nil {
+		return Conf().Chat.ReplyMode
+	}
+	return string(buf)
+}
+
+// SetGroupReplyMode 设置群的回复方式，mode 为空时恢复默认
+func SetGroupReplyMode(groupID int64, mode string) error {
+	if mode == "" {
+		return Msglog.db.Delete([]byte(groupReplyModeKey(groupID)), nil)
+	}
+	if !slices.Contains(ReplyModes, mode) {
+		return fmt.Errorf("未知的回复方式: %s，可选 %s", mode, strings.Join(ReplyModes, "、"))
+	}
+	return Msglog.db.Put([]byte(groupReplyModeKey(groupID)), []byte(mode), nil)
+}
+
+func groupSharedContextKey(groupID int64) string {
+	return fmt.Sprintf("@admin/group/%d/shared_context", groupID)
+}
+
+// GroupSharedContext 群里的成员是否共享同一份历史消息，没有单独设置时使用配置 history.shared_context
+func GroupSharedContext(groupID int64) bool {
+	buf, err := Msglog.db.Get([]byte(groupSharedContextKey(groupID)), nil)
+	if err != nil {
+		return Conf().History.SharedContext
+	}
+	return string(buf) == "1"
+}
+
+// SetGroupSharedContext 设置群是否共享历史消息，shared 为 nil 时恢复默认
+func SetGroupSharedContext(groupID int64, shared *bool) error {
+	key := []byte(groupSharedContextKey(groupID))
+	switch {
+	case shared == nil:
+		return Msglog.db.Delete(key, nil)
+	case *shared:
+		return Msglog.db.Put(key, []byte("1"), nil)
+	}
+	return Msglog.db.Put(key, []byte("0"), nil)
+}
+
+// idListLock 保护白名单等号码列表的读改写
+var idListLock sync.Mutex
+
+// getIDList 读取以 json 数组保存的号码列表
+func getIDList(key string) []int64 {
+	buf, err := Msglog.db.Get([]byte(key), nil)
+	if err != nil {
+		return nil
+	}
+	var ids []int64
+	_ = json.Unmarshal(buf, &ids)
+	return ids
+}
+
+// addIDList 将号码加入列表，列表按号码排序
+func addIDList(key string, id int64) error {
+	idListLock.Lock()
+	defer idListLock.Unlock()
+	ids := getIDList(key)
+	if slices.Contains(ids, id) {
+		return nil
+	}
+	ids = append(ids, id)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	buf, _ := json.Marshal(ids)
+	return Msglog.db.Put([]byte(key), buf, nil)
+}
+
+// removeIDList 将号码移出列表，号码不在列表中时返回 false
+func removeIDList(key string, id int64) (bool, error) {
+	idListLock.Lock()
+	defer idListLock.Unlock()
+	ids := getIDList(key)
+	i := slices.Index(ids, id)
+	if i < 0 {
+		return false, nil
+	}
+	buf, _ := json.Marshal(slices.Delete(ids, i, i+1))
+	return true, Msglog.db.Put([]byte(key), buf, nil)
+}
+
+// GetAllowList 获取白名单中的用户
+func GetAllowList(name string) []int64 {
+	return getIDList(allowListKey(name))
+}
+
+// InAllowList 用户是否在白名单中
+func InAllowList(name string, userID int64) bool {
+	for _, id := range GetAllowList(name) {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// AddAllowList 将用户加入白名单
+func AddAllowList(name string, userID int64) error {
+	if err := checkAllowList(name); err != nil {
+		return err
+	}
+	return addIDList(allowListKey(name), userID)
+}
+
+// RemoveAllowList 将用户移出白名单
+func RemoveAllowList(name string, userID int64) error {
+	if err := checkAllowList(name); err != nil {
+		return err
+	}
+	ok, err := removeIDList(allowListKey(name), userID)
+	if err == nil && !ok {
+		err = fmt.Errorf("%d 不在 %s 白名单中", userID, name)
+	}
+	return err
+}
+
+func checkAllowList(name string) error {
+	for _, n := range AllowLists {
+		if n == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("未知的白名单: %s，可选 %s", name, strings.Join(AllowLists, "、"))
+}
+
+// 统计计数的前缀
+const statPrefix = "@stats/"
+
+// statLock 保护计数的读改写
+var statLock sync.Mutex
+
+// IncrStat 计数加一，name 形如 provider/openai/ok、group/123/messages
+func IncrStat(name string) {
+	statLock.Lock()
+	defer statLock.Unlock()
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, Stat(name)+1)
+	_ = Msglog.db.Put([]byte(statPrefix+name), buf, nil)
+}
+
+// Stat 返回一个计数
+func Stat(name string) uint64 {
+	buf, err := Msglog.db.Get([]byte(statPrefix+name), nil)
+	if err != nil || len(buf) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(buf)
+}
+
+// Stats 返回指定前缀的所有计数，key 不包含 @stats/ 前缀
+func Stats(prefix string) map[string]uint64 {
+	ret := make(map[string]uint64)
+	iter := Msglog.db.NewIterator(util.BytesPrefix([]byte(statPrefix+prefix)), nil)
+	defer iter.Release()
+	for iter.Next() {
+		if len(iter.Value()) != 8 {
+			continue
+		}
+		ret[strings.TrimPrefix(string(iter.Key()), statPrefix)] = binary.BigEndian.Uint64(iter.Value())
+	}
+	return ret
+}
@@ -0,0 +1,164 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 审计记录的前缀，key 为 @audit/<纳秒时间戳>/<序号>，按时间排序
+const auditPrefix = "@audit/"
+
+// Usage 一次回复的模型和 token 用量，工具调用等多次请求会累加
+type Usage struct {
+	Model            string `json:"model,omitempty"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+}
+
+// addUsage 记录提供者的用量，由各提供者在收到模型响应后调用
+func (c *Conversation) addUsage(model string, prompt, completion int64) {
+	c.usage.Model = model
+	c.usage.PromptTokens += prompt
+	c.usage.CompletionTokens += completion
+}
+
+// Usage 返回最近一次 CallProvider 的用量
+func (c *Conversation) Usage() Usage {
+	return c.usage
+}
+
+// AuditEntry 一次提供者调用的审计记录
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Key      string    `json:"key"` // 对话的 key
+	GroupID  int64     `json:"group_id"`
+	UserID   int64     `json:"user_id"`
+	Provider string    `json:"provider"`
+	Kind     string    `json:"kind,omitempty"` // 为空时是回复用户的调用
+	Usage
+	Cost      float64 `json:"cost,omitempty"` // 按价格表估算的费用
+	LatencyMs int64   `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// AuditFilter 查询审计记录的条件，零值表示不限制
+type AuditFilter struct {
+	UserID   int64
+	GroupID  int64
+	Provider string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+}
+
+var (
+	auditLock sync.Mutex
+	auditSeq  uint32
+	// auditCleaned 上次清理过期审计记录的时间
+	auditCleaned time.Time
+)
+
+// AuditKindSummary 压缩历史消息的调用
+const AuditKindSummary = "summary"
+
+// CallProvider 调用提供者，记录审计日志并计入用量
+func CallProvider(ctx context.Context, p Provider, conv *Conversation) (string, error) {
+	conv.usage = Usage{}
+	start := time.Now()
+	text, err := p.Reply(ctx, conv)
+	recordCall(conv.GroupID, conv.UserID, p.Name(), "", conv.usage, start, err)
+	return text, err
+}
+
+// recordCall 保存一次模型调用的审计记录，并计入用户、群、全局和提供者的用量
+func recordCall(groupID, userID int64, provider, kind string, usage Usage, start time.Time, err error) {
+	entry := &AuditEntry{
+		Time:      start,
+		Key:       Msglog.MakeKey(groupID, userID),
+		GroupID:   groupID,
+		UserID:    userID,
+		Provider:  provider,
+		Kind:      kind,
+		Usage:     usage,
+		Cost:      usage.Cost(),
+		LatencyMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	RecordAudit(entry)
+	RecordUsage(groupID, userID, provider, usage)
+}
+
+// RecordAudit 保存审计记录，并定期清理超过保留天数的记录
+func RecordAudit(e *AuditEntry) {
+	auditLock.Lock()
+	auditSeq++
+	key := fmt.Sprintf("%s%020d/%010d", auditPrefix, e.Time.UnixNano(), auditSeq)
+	clean := time.Since(auditCleaned) > time.Hour
+	if clean {
+		auditCleaned = time.Now()
+	}
+	auditLock.Unlock()
+	buf, _ := json.Marshal(e)
+	if err := Msglog.db.Put([]byte(key), buf, nil); err != nil {
+		log.Errorf("保存审计记录失败 err:%v", err)
+	}
+	if clean {
+		goBackground(func(context.Context) {
+			cleanAudit(time.Now().AddDate(0, 0, -Conf().Audit.RetentionDays))
+		})
+	}
+}
+
+// cleanAudit 删除 before 之前的审计记录
+func cleanAudit(before time.Time) {
+	iter := Msglog.db.NewIterator(&util.Range{
+		Start: []byte(auditPrefix),
+		Limit: []byte(fmt.Sprintf("%s%020d", auditPrefix, before.UnixNano())),
+	}, nil)
+	defer iter.Release()
+	removed := 0
+	for iter.Next() {
+		_ = Msglog.db.Delete(iter.Key(), nil)
+		removed++
+	}
+	if removed > 0 {
+		log.Infof("清理过期的审计记录 %d 条", removed)
+	}
+}
+
+// QueryAudit 按条件查询审计记录，最新的在前
+func QueryAudit(f AuditFilter) []*AuditEntry {
+	r := util.BytesPrefix([]byte(auditPrefix))
+	if !f.Since.IsZero() {
+		r.Start = []byte(fmt.Sprintf("%s%020d", auditPrefix, f.Since.UnixNano()))
+	}
+	if !f.Until.IsZero() {
+		r.Limit = []byte(fmt.Sprintf("%s%020d", auditPrefix, f.Until.UnixNano()))
+	}
+	iter := Msglog.db.NewIterator(r, nil)
+	defer iter.Release()
+	var ret []*AuditEntry
+	for ok := iter.Last(); ok; ok = iter.Prev() {
+		var e AuditEntry
+		if err := json.Unmarshal(iter.Value(), &e); err != nil {
+			continue
+		}
+		if (f.UserID != 0 && e.UserID != f.UserID) || (f.GroupID != 0 && e.GroupID != f.GroupID) ||
+			(f.Provider != "" && e.Provider != f.Provider) {
+			continue
+		}
+		ret = append(ret, &e)
+		if f.Limit > 0 && len(ret) >= f.Limit {
+			break
+		}
+	}
+	return ret
+}
@@ -0,0 +1,213 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Price 模型价格，单位为每百万 token 的费用
+type Price struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// Quota 一个周期内的额度，为 0 时不限制
+type Quota struct {
+	Tokens int64   `yaml:"tokens"`
+	Cost   float64 `yaml:"cost"`
+}
+
+// QuotaSet 按用户、群、全局和提供者的额度
+type QuotaSet struct {
+	User      Quota            `yaml:"user"`
+	Group     Quota            `yaml:"group"`
+	Global    Quota            `yaml:"global"`
+	Providers map[string]Quota `yaml:"providers"` // 按提供者的额度，用完后跳过该提供者
+}
+
+// UsageScopeProvider 按提供者统计的用量范围，key 为 provider/<名称>
+const UsageScopeProvider = "provider"
+
+// Cost 按价格表估算费用，没有配置价格的模型费用为 0
+func (u Usage) Cost() float64 {
+	p, ok := Conf().Budget.Prices[u.Model]
+	if !ok {
+		return 0
+	}
+	return (float64(u.PromptTokens)*p.Input + float64(u.CompletionTokens)*p.Output) / 1e6
+}
+
+// UsageTotal 一个范围在一个周期内累计的用量
+type UsageTotal struct {
+	Tokens int64   `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+// 统计周期
+const (
+	PeriodDay   = "day"
+	PeriodMonth = "month"
+)
+
+// periodKey 周期的 key，按时间排序
+func periodKey(period string, t time.Time) string {
+	if period == PeriodMonth {
+		return PeriodMonth + "/" + t.Format("200601")
+	}
+	return PeriodDay + "/" + t.Format("20060102")
+}
+
+// usageScopes 对话计入用量的范围，provider 为空时不包括提供者
+func usageScopes(groupID, userID int64, provider string) []string {
+	scopes := []string{fmt.Sprintf("%s/%d", LimitScopeUser, userID)}
+	if groupID != 0 {
+		scopes = append(scopes, fmt.Sprintf("%s/%d", LimitScopeGroup, groupID))
+	}
+	scopes = append(scopes, LimitScopeGlobal)
+	if provider != "" {
+		scopes = append(scopes, UsageScopeProvider+"/"+provider)
+	}
+	return scopes
+}
+
+func usageKey(period string, t time.Time, scope string) string {
+	return "@usage/" + periodKey(period, t) + "/" + scope
+}
+
+var (
+	usageLock sync.Mutex
+	// usageCleaned 上次清理过期用量的日期
+	usageCleaned string
+)
+
+// GetUsage 查询范围在 t 所在周期的用量
+func GetUsage(period string, t time.Time, scope string) UsageTotal {
+	var total UsageTotal
+	buf, err := Msglog.db.Get([]byte(usageKey(period, t, scope)), nil)
+	if err == nil {
+		_ = json.Unmarshal(buf, &total)
+	}
+	return total
+}
+
+// RecordUsage 将一次回复的用量计入用户、群、全局和提供者的日用量和月用量
+func RecordUsage(groupID, userID int64, provider string, u Usage) {
+	tokens := u.PromptTokens + u.CompletionTokens
+	if tokens == 0 {
+		return
+	}
+	cost := u.Cost()
+	now := time.Now()
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	for _, period := range []string{PeriodDay, PeriodMonth} {
+		for _, scope := range usageScopes(groupID, userID, provider) {
+			total := GetUsage(period, now, scope)
+			total.Tokens += tokens
+			total.Cost += cost
+			buf, _ := json.Marshal(total)
+			if err := Msglog.db.Put([]byte(usageKey(period, now, scope)), buf, nil); err != nil {
+				log.Errorf("保存用量失败 err:%v", err)
+			}
+		}
+	}
+	if today := periodKey(PeriodDay, now); usageCleaned != today {
+		usageCleaned = today
+		goBackground(func(context.Context) {
+			cleanUsage(PeriodDay, now.AddDate(0, 0, -40))
+			cleanUsage(PeriodMonth, now.AddDate(0, -13, 0))
+		})
+	}
+}
+
+// cleanUsage 删除 before 所在周期之前的用量
+func cleanUsage(period string, before time.Time) {
+	iter := Msglog.db.NewIterator(&util.Range{
+		Start: []byte("@usage/" + period + "/"),
+		Limit: []byte("@usage/" + periodKey(period, before)),
+	}, nil)
+	defer iter.Release()
+	for iter.Next() {
+		_ = Msglog.db.Delete(iter.Key(), nil)
+	}
+}
+
+// QuotaUsage 一个范围在一个周期内的用量和额度
+type QuotaUsage struct {
+	Period string // PeriodDay 或 PeriodMonth
+	Scope  string // user/<QQ>、group/<群号>、global 或 provider/<名称>
+	Used   UsageTotal
+	Quota  Quota
+}
+
+// Exhausted 用量是否已经达到额度
+func (q QuotaUsage) Exhausted() bool {
+	return (q.Quota.Tokens > 0 && q.Used.Tokens >= q.Quota.Tokens) ||
+		(q.Quota.Cost > 0 && q.Used.Cost >= q.Quota.Cost)
+}
+
+// IsProvider 是否为提供者的额度
+func (q QuotaUsage) IsProvider() bool {
+	return strings.HasPrefix(q.Scope, UsageScopeProvider+"/")
+}
+
+// Quotas 返回对话在今日和本月的用量和额度，provider 为空时不包括提供者的额度
+func Quotas(groupID, userID int64, provider string) []QuotaUsage {
+	conf := Conf().Budget
+	now := time.Now()
+	var ret []QuotaUsage
+	for _, period := range []string{PeriodDay, PeriodMonth} {
+		set := conf.Daily
+		if period == PeriodMonth {
+			set = conf.Monthly
+		}
+		quotas := []Quota{set.User}
+		if groupID != 0 {
+			quotas = append(quotas, set.Group)
+		}
+		quotas = append(quotas, set.Global)
+		if provider != "" {
+			quotas = append(quotas, set.Providers[provider])
+		}
+		for i, scope := range usageScopes(groupID, userID, provider) {
+			ret = append(ret, QuotaUsage{Period: period, Scope: scope, Used: GetUsage(period, now, scope), Quota: quotas[i]})
+		}
+	}
+	return ret
+}
+
+// BudgetExhausted 对话使用该提供者时任意一个额度用完时返回该额度
+// 提供者的额度用完后不能再降级使用该提供者，优先返回
+func BudgetExhausted(groupID, userID int64, provider string) (QuotaUsage, bool) {
+	var hit QuotaUsage
+	exhausted := false
+	for _, q := range Quotas(groupID, userID, provider) {
+		if !q.Exhausted() {
+			continue
+		}
+		if q.IsProvider() {
+			return q, true
+		}
+		if !exhausted {
+			hit, exhausted = q, true
+		}
+	}
+	return hit, exhausted
+}
+
+// BudgetFallback 额度用完后是否还可以使用该提供者
+func BudgetFallback(provider string) bool {
+	for _, name := range Conf().Budget.FallbackProviders {
+		if name == provider {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,155 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Calculate 计算四则运算表达式，支持 + - * / % ^ 和括号
+func Calculate(expr string) (float64, error) {
+	p := &calcParser{s: strings.ReplaceAll(expr, " ", "")}
+	v, err := p.expr()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos < len(p.s) {
+		return 0, fmt.Errorf("无法解析的字符 %q", p.s[p.pos:])
+	}
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, errors.New("计算结果无效")
+	}
+	return v, nil
+}
+
+// calcParser 递归下降的表达式解析器
+type calcParser struct {
+	s   string
+	pos int
+}
+
+func (p *calcParser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+// expr = term { ("+"|"-") term }
+func (p *calcParser) expr() (float64, error) {
+	v, err := p.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		switch p.peek() {
+		case '+':
+			p.pos++
+			r, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			v += r
+		case '-':
+			p.pos++
+			r, err := p.term()
+			if err != nil {
+				return 0, err
+			}
+			v -= r
+		default:
+			return v, nil
+		}
+	}
+}
+
+// term = power { ("*"|"/"|"%") power }
+func (p *calcParser) term() (float64, error) {
+	v, err := p.power()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return v, nil
+		}
+		p.pos++
+		r, err := p.power()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '*':
+			v *= r
+		case '/':
+			if r == 0 {
+				return 0, errors.New("除数不能为 0")
+			}
+			v /= r
+		case '%':
+			if r == 0 {
+				return 0, errors.New("除数不能为 0")
+			}
+			v = math.Mod(v, r)
+		}
+	}
+}
+
+// power = unary [ "^" power ]
+func (p *calcParser) power() (float64, error) {
+	v, err := p.unary()
+	if err != nil {
+		return 0, err
+	}
+	if p.peek() == '^' {
+		p.pos++
+		r, err := p.power()
+		if err != nil {
+			return 0, err
+		}
+		v = math.Pow(v, r)
+	}
+	return v, nil
+}
+
+// unary = ["-"|"+"] primary
+func (p *calcParser) unary() (float64, error) {
+	switch p.peek() {
+	case '-':
+		p.pos++
+		v, err := p.unary()
+		return -v, err
+	case '+':
+		p.pos++
+		return p.unary()
+	}
+	return p.primary()
+}
+
+// primary = number | "(" expr ")"
+func (p *calcParser) primary() (float64, error) {
+	if p.peek() == '(' {
+		p.pos++
+		v, err := p.expr()
+		if err != nil {
+			return 0, err
+		}
+		if p.peek() != ')' {
+			return 0, errors.New("缺少右括号")
+		}
+		p.pos++
+		return v, nil
+	}
+	start := p.pos
+	for p.pos < len(p.s) && (unicode.IsDigit(rune(p.s[p.pos])) || p.s[p.pos] == '.') {
+		p.pos++
+	}
+	if start == p.pos {
+		return 0, fmt.Errorf("位置 %d 处需要数字", start)
+	}
+	return strconv.ParseFloat(p.s[start:p.pos], 64)
+}
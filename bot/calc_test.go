@@ -0,0 +1,51 @@
+package bot
+
+import "testing"
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		expr string
+		want float64
+	}{
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"10-4-3", 3},
+		{"12/4/3", 1},
+		{"2*3^2", 18},
+		{"2^3^2", 512},
+		{"7%4+1", 4},
+		{"-3+5", 2},
+		{"-(2+3)*2", -10},
+		{"1.5 * 4", 6},
+		{" 8 / ( 3 - 1 ) ", 4},
+	}
+	for _, tt := range tests {
+		got, err := Calculate(tt.expr)
+		if err != nil {
+			t.Errorf("Calculate(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Calculate(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateError(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"1/0",
+		"5%0",
+		"(1+2",
+		"1+",
+		"2*)",
+		"1+2)",
+		"abc",
+		"1..2",
+		"10^1000",
+	} {
+		if v, err := Calculate(expr); err == nil {
+			t.Errorf("Calculate(%q) = %v, want error", expr, v)
+		}
+	}
+}
@@ -3,13 +3,12 @@ package bot
 import (
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/scjtqs2/bot_adapter/client"
 	"github.com/scjtqs2/bot_adapter/coolq"
 	"github.com/scjtqs2/bot_adapter/pb/entity"
 	log "github.com/sirupsen/logrus"
@@ -18,58 +17,76 @@ import (
 	"github.com/openai/openai-go/option"
 )
 
-// chatgpt的配置
-var (
-	// OpenaiEndpoint = "https://wulfs-den.ink/proxy/openai/v1/"
-	OpenaiEndpoint        = "https://api.openai.com/v1/"
-	OpenaiAPIKey          = ""
-	OpenaiModel           = openai.ChatModelGPT4oMini
-	OpenaiReasoningEffort = "low" // 推理努力级别：low|medium|high（适用于o1等推理模型）
-	OpenaiImageUseBase64  = true  // 图片是否使用base64方式而非URL方式
-)
-
-// init 初始化变量
 func init() {
-	if os.Getenv("OPENAI_ENDPOINT") != "" {
-		OpenaiEndpoint = os.Getenv("OPENAI_ENDPOINT")
-	}
-	if os.Getenv("OPENAI_API_KEY") != "" {
-		OpenaiAPIKey = os.Getenv("OPENAI_API_KEY")
-	}
-	if os.Getenv("OPENAI_MODEL") != "" {
-		OpenaiModel = os.Getenv("OPENAI_MODEL")
-	}
-	if os.Getenv("OPENAI_REASONING_EFFORT") != "" {
-		OpenaiReasoningEffort = os.Getenv("OPENAI_REASONING_EFFORT")
-	}
-	if os.Getenv("OPENAI_IMAGE_USE_BASE64") != "" {
-		OpenaiImageUseBase64 = os.Getenv("OPENAI_IMAGE_USE_BASE64") == "true" || os.Getenv("OPENAI_IMAGE_USE_BASE64") == "1"
-	}
+	RegisterProvider(10, openaiProvider{})
+}
+
+// openaiProvider chatgpt 提供者
+type openaiProvider struct{}
+
+func (openaiProvider) Name() string { return "openai" }
+
+func (openaiProvider) Capabilities() Capability { return CapImage | CapHistory | CapStream }
+
+func (openaiProvider) Enabled() bool {
+	conf := Conf().OpenAI
+	return conf.Endpoint != "" && conf.APIKey != ""
+}
+
+func (openaiProvider) Reply(ctx context.Context, conv *Conversation) (string, error) {
+	return ChatGptText(ctx, conv)
 }
 
 // ChatGptText 处理文字
-func ChatGptText(message string, userID int64, groupID int64, botAdapterClient *client.AdapterService) (rsp string, err error) {
-	if OpenaiAPIKey == "" {
+func ChatGptText(ctx context.Context, conv *Conversation) (rsp string, err error) {
+	message, userID, groupID, botAdapterClient := conv.Message, conv.UserID, conv.GroupID, conv.Client
+	conf := Conf().OpenAI
+	if conf.APIKey == "" {
 		return "", errors.New("empyt openai api key")
 	}
 	httpClient := NewHTTPClient(60 * time.Minute)
 	newClient := openai.NewClient(
 		// azure.WithEndpoint(azureOpenAIEndpoint, azureOpenAIAPIVersion),
-		option.WithBaseURL(OpenaiEndpoint),
-		option.WithAPIKey(OpenaiAPIKey), // defaults to os.LookupEnv("OPENAI_API_KEY")
+		option.WithBaseURL(conf.Endpoint),
+		option.WithAPIKey(conf.APIKey), // defaults to os.LookupEnv("OPENAI_API_KEY")
 		option.WithHTTPClient(httpClient),
 	)
 	msgs := coolq.DeCode(message) // 将字符串格式转成 array格式
 	aiMessages := make([]openai.ChatCompletionMessageParamUnion, 0)
-	prompt := "你是一个智能助手，你只能用中文回答所有问题。不要使用markdown语法，我不能解析它，请使用纯文本"
-	aiMessages = append(aiMessages, openai.SystemMessage(prompt))
+	persona := ActivePersona(groupID, userID)
+	model := persona.ModelFor("openai", conf.Model)
+	aiMessages = append(aiMessages, openai.SystemMessage(systemPrompt(groupID, userID, persona)))
 	oldMsgLen := 0
 	// if groupID != 0 {
-	oldMsgs := Msglog.GetMsgs(groupID, userID)
+	allMsgs := conv.history()
+	oldMsgs, cut := SelectHistory(allMsgs, TokenBudget(model))
 	if oldMsgs != nil {
 		oldMsgLen = len(oldMsgs)
+		// 记录出现过的工具调用，历史被截断后孤立的工具结果会被接口拒绝
+		callIDs := make(map[string]bool)
 		for _, s := range oldMsgs {
 			switch s.MsgType {
+			case MsgTypeToolCall:
+				var calls []toolCall
+				if err := json.Unmarshal([]byte(s.Msg), &calls); err != nil || len(calls) == 0 {
+					continue
+				}
+				asst := openai.ChatCompletionAssistantMessageParam{}
+				for _, c := range calls {
+					callIDs[c.ID] = true
+					asst.ToolCalls = append(asst.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+						ID: c.ID,
+						Function: openai.ChatCompletionMessageToolCallFunctionParam{
+							Name:      c.Name,
+							Arguments: c.Arguments,
+						},
+					})
+				}
+				aiMessages = append(aiMessages, openai.ChatCompletionMessageParamUnion{OfAssistant: &asst})
+			case MsgTypeToolResult:
+				if callIDs[s.ToolCallID] {
+					aiMessages = append(aiMessages, openai.ToolMessage(s.Msg, s.ToolCallID))
+				}
 			case MsgTypeText:
 				if s.IsSystem {
 					// 系统消息只能在开头，历史消息中的系统消息作为assistant消息处理
@@ -99,6 +116,7 @@ func ChatGptText(message string, userID int64, groupID int64, botAdapterClient *
 	defer func() {
 		if err == nil {
 			Msglog.AddMsg(groupID, userID, rsp, true, MsgTypeText, "")
+			compactHistory(groupID, userID, "openai", allMsgs, cut, openaiSummarizer(newClient, model))
 		}
 	}()
 	for _, msg := range msgs {
@@ -117,7 +135,7 @@ func ChatGptText(message string, userID int64, groupID int64, botAdapterClient *
 			var imageURL string
 			switch {
 			case strings.HasPrefix(f, "http"):
-				if OpenaiImageUseBase64 {
+				if conf.ImageUseBase64 {
 					var b []byte
 					r := Request{URL: f, Limit: maxImageSize}
 					b, contentType, err = r.Bytes()
@@ -133,7 +151,7 @@ func ChatGptText(message string, userID int64, groupID int64, botAdapterClient *
 					imageURL = f
 				}
 			case strings.HasPrefix(f, "file"):
-				img, err := botAdapterClient.GetImage(context.TODO(), &entity.GetImageReq{File: f})
+				img, err := botAdapterClient.GetImage(ctx, &entity.GetImageReq{File: f})
 				if err != nil {
 					log.Errorf("GetImage failed err=%v", err)
 					continue // 修复点：获取图片失败必须跳过
@@ -178,21 +196,24 @@ func ChatGptText(message string, userID int64, groupID int64, botAdapterClient *
 		}
 	}
 	if len(aiMessages) == oldMsgLen {
-		return "", errors.New("empty")
+		return "", ErrEmpty
 	}
 	// 配置超时时间
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Minute)
 	defer cancel()
 
 	// 构建请求参数
 	params := openai.ChatCompletionNewParams{
 		Messages: aiMessages,
-		Model:    OpenaiModel,
+		Model:    model,
 		// MaxTokens: openai.Int(1000),
 	}
+	if persona.Temperature != nil {
+		params.Temperature = openai.Float(*persona.Temperature)
+	}
 
 	// 设置推理努力级别（适用于 o-series 模型）
-	switch OpenaiReasoningEffort {
+	switch conf.ReasoningEffort {
 	case "low":
 		params.ReasoningEffort = openai.ReasoningEffortLow
 	case "medium":
@@ -201,13 +222,39 @@ func ChatGptText(message string, userID int64, groupID int64, botAdapterClient *
 		params.ReasoningEffort = openai.ReasoningEffortHigh
 	}
 
-	chatCompletion, err := newClient.Chat.Completions.New(ctx, params)
-
-	if err != nil {
-		return "", err
+	if conf.ToolsEnabled {
+		params.Tools = openaiTools()
 	}
-	if len(chatCompletion.Choices) == 0 {
-		return "", errors.New("no choices returned")
+	for step := 0; ; step++ {
+		if conf.ToolsEnabled && step >= conf.ToolMaxSteps {
+			// 超过最大轮数后不再允许调用工具，要求模型直接回答
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: openai.String("none")}
+		}
+		completion, err := chatCompletion(ctx, newClient, params, conv, conf.Stream)
+		if err != nil {
+			return "", err
+		}
+		if len(completion.ToolCalls) == 0 {
+			return completion.Content, nil
+		}
+		if step >= conf.ToolMaxSteps {
+			// 部分兼容接口会忽略 tool_choice，仍然返回工具调用时直接结束，避免无限调用
+			if completion.Content != "" {
+				return completion.Content, nil
+			}
+			return "", fmt.Errorf("工具调用超过 %d 轮", conf.ToolMaxSteps)
+		}
+		params.Messages = append(params.Messages, completion.ToParam())
+		calls := make([]toolCall, 0, len(completion.ToolCalls))
+		for _, c := range completion.ToolCalls {
+			calls = append(calls, toolCall{ID: c.ID, Name: c.Function.Name, Arguments: c.Function.Arguments})
+		}
+		buf, _ := json.Marshal(calls)
+		Msglog.AddObj(groupID, userID, MsgObj{IsSystem: true, Msg: string(buf), MsgType: MsgTypeToolCall})
+		for _, c := range calls {
+			result := callTool(ctx, conv, c.Name, c.Arguments)
+			params.Messages = append(params.Messages, openai.ToolMessage(result, c.ID))
+			Msglog.AddObj(groupID, userID, MsgObj{IsSystem: true, Msg: result, MsgType: MsgTypeToolResult, ToolCallID: c.ID})
+		}
 	}
-	return chatCompletion.Choices[0].Message.Content, nil
 }
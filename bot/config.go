@@ -0,0 +1,646 @@
+package bot
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/openai/openai-go"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config 应用配置，先读取配置文件，再用环境变量覆盖
+type Config struct {
+	App          AppConfig        `yaml:"app"`
+	OpenAI       OpenAIConfig     `yaml:"openai"`
+	Gemini       GeminiConfig     `yaml:"gemini"`
+	LmStudio     LmStudioConfig   `yaml:"lmstudio"`
+	Tuling       TulingConfig     `yaml:"tuling"`
+	Chat         ChatConfig       `yaml:"chat"`
+	History      HistoryConfig    `yaml:"history"`
+	SMS          SMSConfig        `yaml:"sms"`
+	Admin        AdminConfig      `yaml:"admin"`
+	Worker       WorkerConfig     `yaml:"worker"`
+	Audit        AuditConfig      `yaml:"audit"`
+	RateLimit    RateLimitConfig  `yaml:"rate_limit"`
+	Budget       BudgetConfig     `yaml:"budget"`
+	Moderation   ModerationConfig `yaml:"moderation"`
+	Trigger      TriggerRule      `yaml:"trigger"` // 群消息默认的触发规则，可以用 #admin trigger 按群修改
+	GroupLog     GroupLogConfig   `yaml:"group_log"`
+	DedupTTL     int              `yaml:"dedup_ttl"`      // 推送去重记录的有效期，单位秒
+	UseCustomDNS bool             `yaml:"use_custom_dns"` // 是否使用自定义 DNS
+	// ShutdownTimeout 关闭时等待处理中的推送的时间，单位秒，超时后取消正在进行的 AI 请求
+	ShutdownTimeout int `yaml:"shutdown_timeout"`
+}
+
+// AppConfig 对接 bot_adapter 的配置，修改后需要重启
+type AppConfig struct {
+	ID          string `yaml:"id"`
+	Secret      string `yaml:"secret"`
+	EncryptKey  string `yaml:"encrypt_key"` // 推送解密的密码，轮换时可以用逗号分隔多个
+	AdapterAddr string `yaml:"adapter_addr"`
+	HTTPPort    string `yaml:"http_port"`
+	DBPath      string `yaml:"db_path"` // 保存历史消息等数据的 leveldb 目录
+	// VerifySignature 是否校验推送的签名和时间戳，并拒绝重复的推送
+	VerifySignature bool `yaml:"verify_signature"`
+	// TimestampWindow 推送时间戳允许的偏差，单位秒
+	TimestampWindow int `yaml:"timestamp_window"`
+}
+
+// EncryptKeys 返回所有推送解密的密码
+func (c AppConfig) EncryptKeys() []string {
+	var keys []string
+	for _, key := range strings.Split(c.EncryptKey, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// OpenAIConfig chatgpt 的配置
+type OpenAIConfig struct {
+	Endpoint        string `yaml:"endpoint"`
+	APIKey          string `yaml:"api_key"`
+	Model           string `yaml:"model"`
+	ReasoningEffort string `yaml:"reasoning_effort"` // 推理努力级别：low|medium|high（适用于o1等推理模型）
+	ImageUseBase64  bool   `yaml:"image_use_base64"` // 图片是否使用base64方式而非URL方式
+	Stream          bool   `yaml:"stream"`           // 是否使用流式输出，按句子分段发送
+	ToolsEnabled    bool   `yaml:"tools_enabled"`    // 是否允许模型调用内置工具
+	ToolMaxSteps    int    `yaml:"tool_max_steps"`   // 单次回复中最多进行几轮工具调用
+}
+
+// GeminiConfig gemini 的配置
+type GeminiConfig struct {
+	Endpoint           string `yaml:"endpoint"`
+	APIKey             string `yaml:"api_key"`
+	Model              string `yaml:"model"`
+	Proxy              string `yaml:"proxy"`                // 代理地址，例如 http://127.0.0.1:7890
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // 是否跳过TLS证书验证
+}
+
+// LmStudioConfig lm studio / ollama 的配置
+type LmStudioConfig struct {
+	Endpoint string `yaml:"endpoint"` // lm studio:http://192.168.1.123:1234/v1/    ollama: http://192.168.1.123:11434/v1/
+	APIKey   string `yaml:"api_key"`
+	Model    string `yaml:"model"`
+	Stream   bool   `yaml:"stream"`
+}
+
+// TulingConfig 图灵机器人的配置
+type TulingConfig struct {
+	Key string `yaml:"key"`
+}
+
+// ChatConfig 聊天相关的配置
+type ChatConfig struct {
+	ProviderChain  []string `yaml:"provider_chain"`   // 默认的提供者顺序，为空时按注册优先级
+	DefaultPrompt  string   `yaml:"default_prompt"`   // 默认人设的系统提示词
+	StreamMinChunk int      `yaml:"stream_min_chunk"` // 流式输出时每条消息最少的字数
+	ReplyMode      string   `yaml:"reply_mode"`       // 群聊中回复的方式：at、quote、both，可以用 #admin reply 按群修改
+}
+
+// HistoryConfig 历史消息的配置
+type HistoryConfig struct {
+	MaxEntries   int            `yaml:"max_entries"`   // 每个对话最多保存的历史消息条数
+	TokenBudget  int            `yaml:"token_budget"`  // 默认的历史消息 token 预算
+	ImageTokens  int            `yaml:"image_tokens"`  // 每张图片估算的 token 数
+	ModelBudgets map[string]int `yaml:"model_budgets"` // 按模型配置的 token 预算
+	Summary      bool           `yaml:"summary"`       // 超出预算的历史是否压缩成摘要
+	// SharedContext 群里的成员是否共享同一份历史消息，可以用 #admin context 按群修改
+	SharedContext bool `yaml:"shared_context"`
+}
+
+// SMSConfig 短信发送的配置
+type SMSConfig struct {
+	Enabled      bool    `yaml:"enabled"`
+	APIURL       string  `yaml:"api_url"`
+	APISecret    string  `yaml:"api_secret"` // 必须与 http_handler 服务中设置的 FORWARD_SECRET 一致
+	AllowedUsers []int64 `yaml:"allowed_users"`
+}
+
+// AdminConfig 管理员的配置
+type AdminConfig struct {
+	Superusers []int64 `yaml:"superusers"` // 超级管理员的 QQ 号
+}
+
+// WorkerConfig 处理推送的工作池配置
+type WorkerConfig struct {
+	Count      int `yaml:"count"`       // 同时处理的推送数，修改后需要重启
+	QueueSize  int `yaml:"queue_size"`  // 每个对话最多排队的推送数
+	MaxPending int `yaml:"max_pending"` // 所有对话最多排队的推送数
+}
+
+// AuditConfig AI 调用审计日志的配置
+type AuditConfig struct {
+	RetentionDays int    `yaml:"retention_days"` // 审计记录保留的天数
+	Token         string `yaml:"token"`          // 查询接口的 Bearer token，为空时不开放查询接口
+}
+
+// RateLimitConfig AI 回复的限流配置，群主、群管理员和超级管理员不受限制
+type RateLimitConfig struct {
+	LimitSet `yaml:",inline"` // 调用提供者之前检查的限流
+	// Providers 按提供者单独限流，超限时跳过该提供者，使用链路中的下一个
+	Providers      map[string]LimitSet `yaml:"providers"`
+	NoticeInterval int                 `yaml:"notice_interval"` // 同一个群或私聊两次限流提示的最短间隔，单位秒
+}
+
+// BudgetConfig token 用量和费用额度的配置
+type BudgetConfig struct {
+	Prices  map[string]Price `yaml:"prices"`  // 按模型配置的价格，单位为每百万 token 的费用
+	Daily   QuotaSet         `yaml:"daily"`   // 每日额度
+	Monthly QuotaSet         `yaml:"monthly"` // 每月额度
+	// FallbackProviders 额度用完后仍然可以使用的提供者，链路中没有这些提供者时拒绝回复
+	FallbackProviders []string `yaml:"fallback_providers"`
+}
+
+// ModerationConfig 内容审核的配置，没有关键词、正则和审核接口时不审核
+type ModerationConfig struct {
+	Keywords      []string `yaml:"keywords"`       // 关键词，不区分大小写
+	Patterns      []string `yaml:"patterns"`       // 正则表达式
+	Endpoint      string   `yaml:"endpoint"`       // openai 兼容的审核接口地址，为空时只使用本地规则
+	APIKey        string   `yaml:"api_key"`        // 审核接口的 key
+	Model         string   `yaml:"model"`          // 审核模型
+	InputActions  []string `yaml:"input_actions"`  // 提问命中时的处理方式：mask、refuse、notify
+	OutputActions []string `yaml:"output_actions"` // 回复命中时的处理方式：mask、refuse、notify
+	RefuseReply   string   `yaml:"refuse_reply"`   // 拒绝时的回复
+}
+
+// GroupLogConfig 群聊记录的配置，需要用 #admin grouplog 在群里开启
+type GroupLogConfig struct {
+	MaxEntries     int `yaml:"max_entries"`     // 每个群最多保存的消息条数
+	MaxAge         int `yaml:"max_age"`         // 消息保存的时间，单位秒
+	ContextEntries int `yaml:"context_entries"` // 触发机器人时最多附带的消息条数
+}
+
+// DefaultConfig 默认配置
+func DefaultConfig() *Config {
+	return &Config{
+		App: AppConfig{
+			HTTPPort:        "8080",
+			DBPath:          "/data/msgdb",
+			VerifySignature: true,
+			TimestampWindow: 300,
+		},
+		OpenAI: OpenAIConfig{
+			Endpoint:        "https://api.openai.com/v1/",
+			Model:           openai.ChatModelGPT4oMini,
+			ReasoningEffort: "low",
+			ImageUseBase64:  true,
+			ToolMaxSteps:    5,
+		},
+		Gemini: GeminiConfig{
+			Endpoint:           "https://generativelanguage.googleapis.com",
+			Model:              "gemini-1.5-flash",
+			InsecureSkipVerify: true,
+		},
+		Chat: ChatConfig{
+			DefaultPrompt:  "你是一个智能助手，你只能用中文回答所有问题。不要使用markdown语法，我不能解析它，请使用纯文本",
+			StreamMinChunk: 100,
+			ReplyMode:      ReplyModeAt,
+		},
+		History: HistoryConfig{
+			MaxEntries:   100,
+			TokenBudget:  8000,
+			ImageTokens:  1000,
+			ModelBudgets: make(map[string]int),
+			Summary:      true,
+		},
+		SMS: SMSConfig{
+			APIURL: "http://192.168.50.124:1285/api/v1/sms/send",
+		},
+		Worker: WorkerConfig{
+			Count:      8,
+			QueueSize:  5,
+			MaxPending: 100,
+		},
+		Audit: AuditConfig{
+			RetentionDays: 30,
+		},
+		RateLimit: RateLimitConfig{
+			LimitSet: LimitSet{
+				User:  Limit{Rate: 10, Burst: 5},
+				Group: Limit{Rate: 30, Burst: 10},
+			},
+			Providers:      make(map[string]LimitSet),
+			NoticeInterval: 60,
+		},
+		Budget: BudgetConfig{
+			Prices:            make(map[string]Price),
+			FallbackProviders: []string{"lmstudio", "tuling", "qingyunke"},
+		},
+		Trigger: TriggerRule{
+			Prefixes: []string{"#"},
+		},
+		GroupLog: GroupLogConfig{
+			MaxEntries:     100,
+			MaxAge:         3600,
+			ContextEntries: 20,
+		},
+		Moderation: ModerationConfig{
+			Model:         "omni-moderation-latest",
+			InputActions:  []string{ModerationRefuse},
+			OutputActions: []string{ModerationMask},
+			RefuseReply:   "这个话题我不方便回答，换个话题吧",
+		},
+		DedupTTL:        600,
+		ShutdownTimeout: 30,
+	}
+}
+
+// current 当前生效的配置，热加载时整体替换，处理中的消息继续使用旧配置
+var current atomic.Pointer[Config]
+
+func init() {
+	conf := DefaultConfig()
+	applyEnv(conf)
+	current.Store(conf)
+}
+
+// Conf 返回当前生效的配置，调用方不要修改返回值
+func Conf() *Config {
+	return current.Load()
+}
+
+// SetConfig 替换当前配置
+func SetConfig(conf *Config) {
+	current.Store(conf)
+}
+
+// ConfigFile 配置文件路径
+func ConfigFile() string {
+	if os.Getenv("CONFIG_FILE") != "" {
+		return os.Getenv("CONFIG_FILE")
+	}
+	return "/data/config.yaml"
+}
+
+// LoadConfig 读取配置文件并用环境变量覆盖，文件不存在时只使用默认值和环境变量
+func LoadConfig(path string) (*Config, error) {
+	conf := DefaultConfig()
+	buf, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		dec := yaml.NewDecoder(bytes.NewReader(buf))
+		dec.KnownFields(true)
+		if err := dec.Decode(conf); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+		}
+	case os.IsNotExist(err):
+		log.Infof("配置文件 %s 不存在，使用默认配置和环境变量", path)
+	default:
+		return nil, fmt.Errorf("读取配置文件 %s 失败: %v", path, err)
+	}
+	applyEnv(conf)
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+// Validate 校验配置，返回所有错误
+func (c *Config) Validate() error {
+	var errs []string
+	checkURL := func(name, value string) {
+		if value == "" {
+			return
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("%s 不是有效的 http(s) 地址: %q", name, value))
+		}
+	}
+	if len(c.App.EncryptKeys()) == 0 {
+		errs = append(errs, "app.encrypt_key 不能为空")
+	}
+	if c.App.TimestampWindow <= 0 {
+		errs = append(errs, "app.timestamp_window 必须大于 0")
+	}
+	if c.App.DBPath == "" {
+		errs = append(errs, "app.db_path 不能为空")
+	}
+	checkURL("openai.endpoint", c.OpenAI.Endpoint)
+	checkURL("gemini.endpoint", c.Gemini.Endpoint)
+	checkURL("gemini.proxy", c.Gemini.Proxy)
+	checkURL("lmstudio.endpoint", c.LmStudio.Endpoint)
+	if c.SMS.Enabled {
+		checkURL("sms.api_url", c.SMS.APIURL)
+	}
+	switch c.OpenAI.ReasoningEffort {
+	case "", "low", "medium", "high":
+	default:
+		errs = append(errs, fmt.Sprintf("openai.reasoning_effort 只能是 low、medium 或 high: %q", c.OpenAI.ReasoningEffort))
+	}
+	if c.OpenAI.ToolMaxSteps <= 0 {
+		errs = append(errs, "openai.tool_max_steps 必须大于 0")
+	}
+	if c.LmStudio.Endpoint != "" && c.LmStudio.Model == "" {
+		errs = append(errs, "配置了 lmstudio.endpoint 时必须同时配置 lmstudio.model")
+	}
+	if len(c.Chat.ProviderChain) > 0 {
+		if err := ValidateChain(c.Chat.ProviderChain); err != nil {
+			errs = append(errs, "chat.provider_chain: "+err.Error())
+		}
+	}
+	if c.Chat.DefaultPrompt == "" {
+		errs = append(errs, "chat.default_prompt 不能为空")
+	}
+	if c.Chat.StreamMinChunk <= 0 {
+		errs = append(errs, "chat.stream_min_chunk 必须大于 0")
+	}
+	if !slices.Contains(ReplyModes, c.Chat.ReplyMode) {
+		errs = append(errs, fmt.Sprintf("chat.reply_mode 无效: %q，可选 %s", c.Chat.ReplyMode, strings.Join(ReplyModes, "、")))
+	}
+	if c.History.MaxEntries <= 0 {
+		errs = append(errs, "history.max_entries 必须大于 0")
+	}
+	if c.History.TokenBudget <= 0 {
+		errs = append(errs, "history.token_budget 必须大于 0")
+	}
+	if c.History.ImageTokens < 0 {
+		errs = append(errs, "history.image_tokens 不能小于 0")
+	}
+	for model, n := range c.History.ModelBudgets {
+		if n <= 0 {
+			errs = append(errs, fmt.Sprintf("history.model_budgets.%s 必须大于 0", model))
+		}
+	}
+	if c.Worker.Count <= 0 || c.Worker.QueueSize <= 0 || c.Worker.MaxPending <= 0 {
+		errs = append(errs, "worker.count、worker.queue_size、worker.max_pending 必须大于 0")
+	}
+	if c.Audit.RetentionDays <= 0 {
+		errs = append(errs, "audit.retention_days 必须大于 0")
+	}
+	checkLimits := func(name string, set LimitSet) {
+		scopes := []string{LimitScopeUser, LimitScopeGroup, LimitScopeGlobal}
+		for i, l := range []Limit{set.User, set.Group, set.Global} {
+			if l.Rate < 0 || (l.Rate > 0 && l.Burst <= 0) {
+				errs = append(errs, fmt.Sprintf("%s.%s 的 rate 不能小于 0，设置了 rate 时 burst 必须大于 0", name, scopes[i]))
+			}
+		}
+	}
+	checkLimits("rate_limit", c.RateLimit.LimitSet)
+	for name, set := range c.RateLimit.Providers {
+		checkLimits("rate_limit.providers."+name, set)
+	}
+	if c.RateLimit.NoticeInterval < 0 {
+		errs = append(errs, "rate_limit.notice_interval 不能小于 0")
+	}
+	for model, p := range c.Budget.Prices {
+		if p.Input < 0 || p.Output < 0 {
+			errs = append(errs, fmt.Sprintf("budget.prices.%s 不能小于 0", model))
+		}
+	}
+	checkQuotas := func(name string, set QuotaSet) {
+		scopes := []string{LimitScopeUser, LimitScopeGroup, LimitScopeGlobal}
+		for i, q := range []Quota{set.User, set.Group, set.Global} {
+			if q.Tokens < 0 || q.Cost < 0 {
+				errs = append(errs, fmt.Sprintf("%s.%s 不能小于 0", name, scopes[i]))
+			}
+		}
+		for provider, q := range set.Providers {
+			if q.Tokens < 0 || q.Cost < 0 {
+				errs = append(errs, fmt.Sprintf("%s.providers.%s 不能小于 0", name, provider))
+			}
+		}
+	}
+	checkQuotas("budget.daily", c.Budget.Daily)
+	checkQuotas("budget.monthly", c.Budget.Monthly)
+	checkURL("moderation.endpoint", c.Moderation.Endpoint)
+	for _, p := range c.Moderation.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			errs = append(errs, fmt.Sprintf("moderation.patterns 中的 %q 不是有效的正则: %v", p, err))
+		}
+	}
+	checkActions := func(name string, actions []string) {
+		for _, a := range actions {
+			if !slices.Contains(ModerationActions, a) {
+				errs = append(errs, fmt.Sprintf("%s 中的 %q 无效，可选 %s", name, a, strings.Join(ModerationActions, "、")))
+			}
+		}
+	}
+	checkActions("moderation.input_actions", c.Moderation.InputActions)
+	checkActions("moderation.output_actions", c.Moderation.OutputActions)
+	if err := c.Trigger.Validate(); err != nil {
+		errs = append(errs, "trigger: "+err.Error())
+	}
+	if c.GroupLog.MaxEntries <= 0 || c.GroupLog.MaxAge <= 0 || c.GroupLog.ContextEntries <= 0 {
+		errs = append(errs, "group_log.max_entries、group_log.max_age、group_log.context_entries 必须大于 0")
+	}
+	if c.DedupTTL <= 0 {
+		errs = append(errs, "dedup_ttl 必须大于 0")
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, "shutdown_timeout 必须大于 0")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("配置校验失败:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// applyEnv 环境变量覆盖配置文件，兼容之前只用环境变量配置的部署方式
+func applyEnv(c *Config) {
+	envString("APP_ID", &c.App.ID)
+	envString("APP_SECRET", &c.App.Secret)
+	envString("APP_ENCRYPT_KEY", &c.App.EncryptKey)
+	envString("ADAPTER_ADDR", &c.App.AdapterAddr)
+	envString("HTTP_PORT", &c.App.HTTPPort)
+	envString("MSGDB_PATH", &c.App.DBPath)
+	envBool("PUSH_VERIFY_SIGNATURE", &c.App.VerifySignature)
+	envInt("PUSH_TIMESTAMP_WINDOW", &c.App.TimestampWindow)
+
+	envString("OPENAI_ENDPOINT", &c.OpenAI.Endpoint)
+	envString("OPENAI_API_KEY", &c.OpenAI.APIKey)
+	envString("OPENAI_MODEL", &c.OpenAI.Model)
+	envString("OPENAI_REASONING_EFFORT", &c.OpenAI.ReasoningEffort)
+	envBool("OPENAI_IMAGE_USE_BASE64", &c.OpenAI.ImageUseBase64)
+	envBool("OPENAI_STREAM", &c.OpenAI.Stream)
+	envBool("OPENAI_TOOLS_ENABLED", &c.OpenAI.ToolsEnabled)
+	envInt("OPENAI_TOOL_MAX_STEPS", &c.OpenAI.ToolMaxSteps)
+
+	envString("GEMINI_ENDPOINT", &c.Gemini.Endpoint)
+	envString("GEMINI_API_KEY", &c.Gemini.APIKey)
+	envString("GEMINI_MODEL", &c.Gemini.Model)
+	envString("GEMINI_PROXY", &c.Gemini.Proxy)
+	envBool("GEMINI_INSECURE_SKIP_VERIFY", &c.Gemini.InsecureSkipVerify)
+
+	envString("LMSTUDIO_ENDPOINT", &c.LmStudio.Endpoint)
+	envString("LMSTUDIO_API_KEY", &c.LmStudio.APIKey)
+	envString("LMSTUDIO_MODEL", &c.LmStudio.Model)
+	envBool("LMSTUDIO_STREAM", &c.LmStudio.Stream)
+
+	envString("TULING_KEY", &c.Tuling.Key)
+
+	if os.Getenv("PROVIDER_CHAIN") != "" {
+		c.Chat.ProviderChain = ParseChain(os.Getenv("PROVIDER_CHAIN"))
+	}
+	envString("DEFAULT_PROMPT", &c.Chat.DefaultPrompt)
+	envInt("STREAM_MIN_CHUNK", &c.Chat.StreamMinChunk)
+	envString("REPLY_MODE", &c.Chat.ReplyMode)
+
+	envInt("HISTORY_MAX_ENTRIES", &c.History.MaxEntries)
+	envInt("HISTORY_TOKEN_BUDGET", &c.History.TokenBudget)
+	envInt("HISTORY_IMAGE_TOKENS", &c.History.ImageTokens)
+	if os.Getenv("HISTORY_MODEL_BUDGETS") != "" {
+		c.History.ModelBudgets = ParseModelBudgets(os.Getenv("HISTORY_MODEL_BUDGETS"))
+	}
+	envBool("HISTORY_SUMMARY", &c.History.Summary)
+	envBool("HISTORY_SHARED_CONTEXT", &c.History.SharedContext)
+
+	envBool("SMS_FEATURE_ENABLED", &c.SMS.Enabled)
+	envString("SMS_API_URL", &c.SMS.APIURL)
+	envString("SMS_API_SECRET", &c.SMS.APISecret)
+	if os.Getenv("SMS_ALLOWED_USERS") != "" {
+		c.SMS.AllowedUsers = ParseIDs(os.Getenv("SMS_ALLOWED_USERS"))
+	}
+
+	envInt("WORKER_COUNT", &c.Worker.Count)
+	envInt("WORKER_QUEUE_SIZE", &c.Worker.QueueSize)
+	envInt("WORKER_MAX_PENDING", &c.Worker.MaxPending)
+
+	if os.Getenv("SUPERUSERS") != "" {
+		c.Admin.Superusers = ParseIDs(os.Getenv("SUPERUSERS"))
+	}
+
+	envInt("AUDIT_RETENTION_DAYS", &c.Audit.RetentionDays)
+	envString("AUDIT_TOKEN", &c.Audit.Token)
+
+	envLimit("RATE_LIMIT_USER", &c.RateLimit.User)
+	envLimit("RATE_LIMIT_GROUP", &c.RateLimit.Group)
+	envLimit("RATE_LIMIT_GLOBAL", &c.RateLimit.Global)
+	envInt("RATE_LIMIT_NOTICE_INTERVAL", &c.RateLimit.NoticeInterval)
+
+	if os.Getenv("BUDGET_FALLBACK_PROVIDERS") != "" {
+		c.Budget.FallbackProviders = ParseChain(os.Getenv("BUDGET_FALLBACK_PROVIDERS"))
+	}
+
+	if os.Getenv("MODERATION_KEYWORDS") != "" {
+		c.Moderation.Keywords = strings.Split(os.Getenv("MODERATION_KEYWORDS"), ",")
+	}
+	envString("MODERATION_ENDPOINT", &c.Moderation.Endpoint)
+	envString("MODERATION_API_KEY", &c.Moderation.APIKey)
+	envString("MODERATION_MODEL", &c.Moderation.Model)
+
+	if os.Getenv("TRIGGER_PREFIXES") != "" {
+		c.Trigger.Prefixes = strings.Split(os.Getenv("TRIGGER_PREFIXES"), ",")
+	}
+	if os.Getenv("TRIGGER_NICKNAMES") != "" {
+		c.Trigger.Nicknames = strings.Split(os.Getenv("TRIGGER_NICKNAMES"), ",")
+	}
+
+	envInt("GROUP_LOG_MAX_ENTRIES", &c.GroupLog.MaxEntries)
+	envInt("GROUP_LOG_MAX_AGE", &c.GroupLog.MaxAge)
+	envInt("GROUP_LOG_CONTEXT_ENTRIES", &c.GroupLog.ContextEntries)
+
+	envInt("DEDUP_TTL", &c.DedupTTL)
+	envInt("SHUTDOWN_TIMEOUT", &c.ShutdownTimeout)
+	envBool("USE_CUSTOM_DNS", &c.UseCustomDNS)
+}
+
+func envString(name string, p *string) {
+	if v := os.Getenv(name); v != "" {
+		*p = v
+	}
+}
+
+func envBool(name string, p *bool) {
+	if v := os.Getenv(name); v != "" {
+		*p = strings.ToLower(v) == "true" || v == "1"
+	}
+}
+
+func envInt(name string, p *int) {
+	if v := os.Getenv(name); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			log.Warnf("环境变量 %s 不是有效的数字: %q，已忽略", name, v)
+			return
+		}
+		*p = n
+	}
+}
+
+func envLimit(name string, p *Limit) {
+	if v := os.Getenv(name); v != "" {
+		l, err := ParseLimit(v)
+		if err != nil {
+			log.Warnf("环境变量 %s 不是有效的限流参数: %q，已忽略", name, v)
+			return
+		}
+		*p = l
+	}
+}
+
+// ParseIDs 解析逗号分隔的 QQ 号列表，无法解析的项会被跳过
+func ParseIDs(s string) []int64 {
+	var ids []int64
+	for _, idStr := range strings.Split(s, ",") {
+		trimmedID := strings.TrimSpace(idStr)
+		if trimmedID == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(trimmedID, 10, 64)
+		if err != nil {
+			log.Warnf("无法解析 ID: '%s'，已跳过", idStr)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// WatchConfig 定时检查配置文件的修改时间，变化后重新加载
+// 加载失败时保留原来的配置，onReload 在配置替换成功后调用
+func WatchConfig(path string, interval time.Duration, stop <-chan struct{}, onReload func(old, conf *Config)) {
+	modTime := fileModTime(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			t := fileModTime(path)
+			if t.Equal(modTime) {
+				continue
+			}
+			modTime = t
+			log.Infof("配置文件 %s 已修改，重新加载", path)
+			ReloadConfig(path, onReload)
+		}
+	}
+}
+
+// ReloadConfig 重新加载配置文件，失败时保留原来的配置
+func ReloadConfig(path string, onReload func(old, conf *Config)) {
+	conf, err := LoadConfig(path)
+	if err != nil {
+		log.Errorf("重新加载配置失败，继续使用原配置: %v", err)
+		return
+	}
+	old := Conf()
+	SetConfig(conf)
+	if onReload != nil {
+		onReload(old, conf)
+	}
+	log.Info("配置已重新加载")
+}
+
+func fileModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 去重记录的前缀，值为过期时间的 unix 秒
+const dedupPrefix = "@dedup/"
+
+var (
+	dedupLock sync.Mutex
+	// dedupCount 记录次数，每记录一定次数清理一次过期的记录
+	dedupCount int
+)
+
+// Seen 检查事件是否在有效期内处理过，没有处理过时记录下来并返回 false
+// 记录保存在 leveldb 中，重启后仍然有效
+func Seen(id string, ttl time.Duration) bool {
+	dedupLock.Lock()
+	defer dedupLock.Unlock()
+	key := []byte(dedupPrefix + id)
+	now := time.Now()
+	if buf, err := Msglog.db.Get(key, nil); err == nil && len(buf) == 8 {
+		if int64(binary.BigEndian.Uint64(buf)) > now.Unix() {
+			return true
+		}
+	}
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(now.Add(ttl).Unix()))
+	_ = Msglog.db.Put(key, buf, nil)
+	dedupCount++
+	if dedupCount >= 1000 {
+		dedupCount = 0
+		cleanDedup(now)
+	}
+	return false
+}
+
+// Recorded 检查事件是否在有效期内处理过，不记录
+func Recorded(id string) bool {
+	if id == "" {
+		return false
+	}
+	buf, err := Msglog.db.Get([]byte(dedupPrefix+id), nil)
+	return err == nil && len(buf) == 8 && int64(binary.BigEndian.Uint64(buf)) > time.Now().Unix()
+}
+
+// cleanDedup 删除过期的去重记录
+func cleanDedup(now time.Time) {
+	iter := Msglog.db.NewIterator(util.BytesPrefix([]byte(dedupPrefix)), nil)
+	defer iter.Release()
+	removed := 0
+	for iter.Next() {
+		if len(iter.Value()) == 8 && int64(binary.BigEndian.Uint64(iter.Value())) > now.Unix() {
+			continue
+		}
+		_ = Msglog.db.Delete(iter.Key(), nil)
+		removed++
+	}
+	log.Debugf("清理过期的去重记录 %d 条", removed)
+}
@@ -23,18 +23,6 @@ const (
 	maxImageSize = 1024 * 1024 * 30 // 30MB
 )
 
-// 自定义 DNS 配置
-var (
-	UseCustomDNS = false // 是否使用自定义 DNS，默认为 false
-)
-
-func init() {
-	// 从环境变量读取配置
-	if os.Getenv("USE_CUSTOM_DNS") == "true" || os.Getenv("USE_CUSTOM_DNS") == "1" {
-		UseCustomDNS = true
-	}
-}
-
 // customResolver 使用指定 DNS 服务器的 resolver
 var customResolver = &net.Resolver{
 	PreferGo: true,
@@ -131,7 +119,7 @@ func dialTLSContext(ctx context.Context, network, address string) (net.Conn, err
 
 // NewHTTPTransport 创建 HTTP Transport，根据配置决定是否使用自定义 DNS
 func NewHTTPTransport() *http.Transport {
-	if UseCustomDNS {
+	if Conf().UseCustomDNS {
 		return &http.Transport{
 			ForceAttemptHTTP2:     false,
 			MaxConnsPerHost:       0,
@@ -7,11 +7,9 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/scjtqs2/bot_adapter/client"
 	"github.com/scjtqs2/bot_adapter/coolq"
 	"github.com/scjtqs2/bot_adapter/pb/entity"
 	log "github.com/sirupsen/logrus"
@@ -23,46 +21,40 @@ import (
  * @email scjtqs@qq.com
  */
 
-// gemini的配置
-var (
-	GeminiEndpoint           = "https://generativelanguage.googleapis.com"
-	GeminiAPIKey             = ""
-	GeminiModel              = "gemini-1.5-flash"
-	GeminiProxy              = ""   // 代理地址，例如 http://127.0.0.1:7890
-	GeminiInsecureSkipVerify = true // 是否跳过TLS证书验证
-)
-
-// init 初始化变量
 func init() {
-	if os.Getenv("GEMINI_ENDPOINT") != "" {
-		GeminiEndpoint = os.Getenv("GEMINI_ENDPOINT")
-	}
-	if os.Getenv("GEMINI_API_KEY") != "" {
-		GeminiAPIKey = os.Getenv("GEMINI_API_KEY")
-	}
-	if os.Getenv("GEMINI_MODEL") != "" {
-		GeminiModel = os.Getenv("GEMINI_MODEL")
-	}
-	if os.Getenv("GEMINI_PROXY") != "" {
-		GeminiProxy = os.Getenv("GEMINI_PROXY")
-	}
-	if os.Getenv("GEMINI_INSECURE_SKIP_VERIFY") != "" {
-		GeminiInsecureSkipVerify = os.Getenv("GEMINI_INSECURE_SKIP_VERIFY") == "true" || os.Getenv("GEMINI_INSECURE_SKIP_VERIFY") == "1"
-	}
+	RegisterProvider(20, geminiProvider{})
+}
+
+// geminiProvider gemini 提供者
+type geminiProvider struct{}
+
+func (geminiProvider) Name() string { return "gemini" }
+
+func (geminiProvider) Capabilities() Capability { return CapImage | CapHistory }
+
+func (geminiProvider) Enabled() bool {
+	conf := Conf().Gemini
+	return conf.Endpoint != "" && conf.APIKey != ""
+}
+
+func (geminiProvider) Reply(ctx context.Context, conv *Conversation) (string, error) {
+	return GeminiText(ctx, conv)
 }
 
 // GeminiText 处理文字
-func GeminiText(message string, userID int64, groupID int64, botAdapterClient *client.AdapterService) (rsp string, err error) {
-	if GeminiAPIKey == "" {
+func GeminiText(ctx context.Context, conv *Conversation) (rsp string, err error) {
+	message, userID, groupID, botAdapterClient := conv.Message, conv.UserID, conv.GroupID, conv.Client
+	conf := Conf().Gemini
+	if conf.APIKey == "" {
 		return "", errors.New("empty gemini api key")
 	}
 	// 配置超时时间
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Minute)
 	defer cancel()
 
 	// 构建客户端配置
 	clientConfig := &genai.ClientConfig{
-		APIKey:  GeminiAPIKey,
+		APIKey:  conf.APIKey,
 		Backend: genai.BackendGeminiAPI,
 	}
 
@@ -74,8 +66,8 @@ func GeminiText(message string, userID int64, groupID int64, botAdapterClient *c
 	}
 
 	// 设置代理
-	if GeminiProxy != "" {
-		proxyURL, err := url.Parse(GeminiProxy)
+	if conf.Proxy != "" {
+		proxyURL, err := url.Parse(conf.Proxy)
 		if err == nil {
 			transport.Proxy = http.ProxyURL(proxyURL)
 		} else {
@@ -96,12 +88,14 @@ func GeminiText(message string, userID int64, groupID int64, botAdapterClient *c
 	var history []*genai.Content
 
 	// 添加系统提示
-	prompt := "你是一个智能助手，你只能用中文回答所有问题。"
-	history = append(history, genai.NewContentFromText(prompt, genai.RoleUser))
+	persona := ActivePersona(groupID, userID)
+	model := persona.ModelFor("gemini", conf.Model)
+	history = append(history, genai.NewContentFromText(systemPrompt(groupID, userID, persona), genai.RoleUser))
 	history = append(history, genai.NewContentFromText("好的", genai.RoleModel))
 
 	// 添加历史消息
-	oldMsgs := Msglog.GetMsgs(groupID, userID)
+	allMsgs := conv.history()
+	oldMsgs, cut := SelectHistory(allMsgs, TokenBudget(model))
 	for _, s := range oldMsgs {
 		switch s.MsgType {
 		case MsgTypeText:
@@ -125,12 +119,24 @@ func GeminiText(message string, userID int64, groupID int64, botAdapterClient *c
 	defer func() {
 		if err == nil {
 			Msglog.AddMsg(groupID, userID, rsp, true, MsgTypeText, "")
+			compactHistory(groupID, userID, "gemini", allMsgs, cut, func(ctx context.Context, prompt string) (string, Usage, error) {
+				resp, err := newClient.Models.GenerateContent(ctx, model, genai.Text(prompt), nil)
+				if err != nil {
+					return "", Usage{Model: model}, err
+				}
+				usage := Usage{Model: model}
+				if resp.UsageMetadata != nil {
+					usage.PromptTokens = int64(resp.UsageMetadata.PromptTokenCount)
+					usage.CompletionTokens = int64(resp.UsageMetadata.CandidatesTokenCount)
+				}
+				return resp.Text(), usage, nil
+			})
 		}
 	}()
 
 	msgs := coolq.DeCode(message) // 将字符串格式转成 array格式
 	if len(msgs) == 0 {
-		return "", errors.New("empty")
+		return "", ErrEmpty
 	}
 
 	var parts []*genai.Part
@@ -159,7 +165,7 @@ func GeminiText(message string, userID int64, groupID int64, botAdapterClient *c
 					mimeType = "image/png"
 				}
 			} else if strings.HasPrefix(f, "file") {
-				img, err := botAdapterClient.GetImage(context.TODO(), &entity.GetImageReq{File: f})
+				img, err := botAdapterClient.GetImage(ctx, &entity.GetImageReq{File: f})
 				if err != nil {
 					log.Errorf("GetImage failed err=%v", err)
 					continue // 获取图片失败必须跳过
@@ -200,7 +206,11 @@ func GeminiText(message string, userID int64, groupID int64, botAdapterClient *c
 	}
 
 	// 创建聊天会话 - 注意参数顺序: model, config, history
-	chat, err := newClient.Chats.Create(ctx, GeminiModel, nil, history)
+	var config *genai.GenerateContentConfig
+	if persona.Temperature != nil {
+		config = &genai.GenerateContentConfig{Temperature: genai.Ptr(float32(*persona.Temperature))}
+	}
+	chat, err := newClient.Chats.Create(ctx, model, config, history)
 	if err != nil {
 		return "", err
 	}
@@ -210,6 +220,9 @@ func GeminiText(message string, userID int64, groupID int64, botAdapterClient *c
 	if err != nil {
 		return "", err
 	}
+	if resp.UsageMetadata != nil {
+		conv.addUsage(model, int64(resp.UsageMetadata.PromptTokenCount), int64(resp.UsageMetadata.CandidatesTokenCount))
+	}
 
 	// 提取响应文本
 	var rspText string
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GroupLogEntry 群里一条没有触发机器人的消息
+type GroupLogEntry struct {
+	Time   int64  `json:"time"` // unix 秒
+	UserID int64  `json:"user_id"`
+	Sender string `json:"sender"` // 群名片或昵称
+	Text   string `json:"text"`
+}
+
+// groupLogMaxRunes 每条消息最多保存的字数
+const groupLogMaxRunes = 200
+
+func groupLogKey(groupID int64) []byte {
+	return []byte(fmt.Sprintf("@grouplog/%d", groupID))
+}
+
+func groupLogEnabledKey(groupID int64) []byte {
+	return []byte(fmt.Sprintf("@admin/group/%d/group_log", groupID))
+}
+
+// groupLogLock 保护群聊记录的读改写
+var groupLogLock sync.Mutex
+
+// GroupLogEnabled 群是否开启了聊天记录，默认关闭
+func GroupLogEnabled(groupID int64) bool {
+	ok, _ := Msglog.db.Has(groupLogEnabledKey(groupID), nil)
+	return ok
+}
+
+// SetGroupLogEnabled 开启或关闭群的聊天记录，关闭时删除已经保存的记录
+func SetGroupLogEnabled(groupID int64, enabled bool) error {
+	if enabled {
+		return Msglog.db.Put(groupLogEnabledKey(groupID), []byte("1"), nil)
+	}
+	if err := Msglog.db.Delete(groupLogEnabledKey(groupID), nil); err != nil {
+		return err
+	}
+	groupLogLock.Lock()
+	defer groupLogLock.Unlock()
+	return Msglog.db.Delete(groupLogKey(groupID), nil)
+}
+
+// getGroupLog 读取群聊记录，按时间顺序
+func getGroupLog(groupID int64) []GroupLogEntry {
+	buf, err := Msglog.db.Get(groupLogKey(groupID), nil)
+	if err != nil {
+		return nil
+	}
+	var entries []GroupLogEntry
+	_ = json.Unmarshal(buf, &entries)
+	return entries
+}
+
+// AppendGroupLog 记录一条没有触发机器人的群消息，只保留最近 group_log.max_entries 条和 group_log.max_age 内的记录
+func AppendGroupLog(groupID int64, e GroupLogEntry) {
+	conf := Conf().GroupLog
+	if r := []rune(e.Text); len(r) > groupLogMaxRunes {
+		e.Text = string(r[:groupLogMaxRunes]) + "…"
+	}
+	groupLogLock.Lock()
+	defer groupLogLock.Unlock()
+	entries := append(getGroupLog(groupID), e)
+	since := time.Now().Add(-time.Duration(conf.MaxAge) * time.Second).Unix()
+	start := max(len(entries)-conf.MaxEntries, 0)
+	for start < len(entries) && entries[start].Time < since {
+		start++
+	}
+	buf, _ := json.Marshal(entries[start:])
+	_ = Msglog.db.Put(groupLogKey(groupID), buf, nil)
+}
+
+// RecentGroupLog 返回 group_log.max_age 内最近的 group_log.context_entries 条记录
+func RecentGroupLog(groupID int64) []GroupLogEntry {
+	conf := Conf().GroupLog
+	groupLogLock.Lock()
+	entries := getGroupLog(groupID)
+	groupLogLock.Unlock()
+	since := time.Now().Add(-time.Duration(conf.MaxAge) * time.Second).Unix()
+	start := max(len(entries)-conf.ContextEntries, 0)
+	for start < len(entries) && entries[start].Time < since {
+		start++
+	}
+	return entries[start:]
+}
+
+// groupLogPrompt 将最近的群聊记录格式化后附加到系统提示词中，没有开启或没有记录时返回空
+func groupLogPrompt(groupID int64) string {
+	if groupID == 0 || !GroupLogEnabled(groupID) {
+		return ""
+	}
+	entries := RecentGroupLog(groupID)
+	if len(entries) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("以下是群里最近没有@你的聊天记录，回答时可以参考：")
+	for _, e := range entries {
+		fmt.Fprintf(&b, "\n[%s] %s：%s", time.Unix(e.Time, 0).Format("15:04"), e.Sender, e.Text)
+	}
+	return b.String()
+}
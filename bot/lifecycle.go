@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+var (
+	// background 后台任务使用的 context，Close 等待超时后取消
+	background, cancelBackground = context.WithCancel(context.Background())
+	// backgroundTasks 正在运行的后台任务
+	backgroundTasks sync.WaitGroup
+)
+
+// goBackground 运行后台任务，Close 时会等待任务结束
+func goBackground(f func(ctx context.Context)) {
+	backgroundTasks.Add(1)
+	go func() {
+		defer backgroundTasks.Done()
+		f(background)
+	}()
+}
+
+// Close 等待后台任务结束后关闭数据库，ctx 超时后取消剩余的后台任务
+func Close(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		backgroundTasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		cancelBackground()
+		// 取消后的任务很快就会返回，这里只做有限的等待
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}
+	cancelBackground()
+	return Msglog.Close()
+}
@@ -8,11 +8,9 @@ import (
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/shared"
-	"github.com/scjtqs2/bot_adapter/client"
 	"github.com/scjtqs2/bot_adapter/coolq"
 	"github.com/scjtqs2/bot_adapter/pb/entity"
 	log "github.com/sirupsen/logrus"
-	"os"
 	"strings"
 	"time"
 )
@@ -22,45 +20,49 @@ import (
  * @email scjtqs@qq.com
  */
 
-// gemini的配置
-var (
-	LmStudioEndpoint = "" // lm studio:http://192.168.1.123:1234/v1/    ollama: http://192.168.1.123:11434/v1/
-	LmStudioAPIKey   = ""
-	LmStudioModel    = ""
-)
-
-// init 初始化变量
 func init() {
-	if os.Getenv("LMSTUDIO_ENDPOINT") != "" {
-		LmStudioEndpoint = os.Getenv("LMSTUDIO_ENDPOINT")
-	}
-	if os.Getenv("LMSTUDIO_API_KEY") != "" {
-		LmStudioAPIKey = os.Getenv("LMSTUDIO_API_KEY")
-	}
-	if os.Getenv("LMSTUDIO_MODEL") != "" {
-		LmStudioModel = os.Getenv("LMSTUDIO_MODEL")
-	}
+	RegisterProvider(30, lmStudioProvider{})
+}
+
+// lmStudioProvider lm studio / ollama 等 openai 兼容接口的本地模型提供者
+type lmStudioProvider struct{}
+
+func (lmStudioProvider) Name() string { return "lmstudio" }
+
+func (lmStudioProvider) Capabilities() Capability { return CapImage | CapHistory | CapStream }
+
+func (lmStudioProvider) Enabled() bool {
+	conf := Conf().LmStudio
+	return conf.Endpoint != "" && conf.Model != ""
+}
+
+func (lmStudioProvider) Reply(ctx context.Context, conv *Conversation) (string, error) {
+	return LmStudioText(ctx, conv)
 }
 
 // LmStudioText 处理文字
-func LmStudioText(message string, userID int64, groupID int64, botAdapterClient *client.AdapterService) (rsp string, err error) {
-	if LmStudioEndpoint == "" || LmStudioModel == "" {
+func LmStudioText(ctx context.Context, conv *Conversation) (rsp string, err error) {
+	message, userID, groupID, botAdapterClient := conv.Message, conv.UserID, conv.GroupID, conv.Client
+	conf := Conf().LmStudio
+	if conf.Endpoint == "" || conf.Model == "" {
 		return "", errors.New("empyt lmstudio api")
 	}
 	httpClient := NewHTTPClient(5 * time.Minute)
 	newClient := openai.NewClient(
 		// azure.WithEndpoint(azureOpenAIEndpoint, azureOpenAIAPIVersion),
-		option.WithBaseURL(LmStudioEndpoint),
-		option.WithAPIKey(LmStudioAPIKey), // defaults to os.LookupEnv("OPENAI_API_KEY")
+		option.WithBaseURL(conf.Endpoint),
+		option.WithAPIKey(conf.APIKey), // defaults to os.LookupEnv("OPENAI_API_KEY")
 		option.WithHTTPClient(httpClient),
 	)
 	msgs := coolq.DeCode(message) // 将字符串格式转成 array格式
 	aiMessages := make([]openai.ChatCompletionMessageParamUnion, 0)
-	prompt := "你是一个智能助手，你只能用中文回答所有问题。"
-	aiMessages = append(aiMessages, openai.SystemMessage(prompt))
+	persona := ActivePersona(groupID, userID)
+	model := persona.ModelFor("lmstudio", conf.Model)
+	aiMessages = append(aiMessages, openai.SystemMessage(systemPrompt(groupID, userID, persona)))
 	oldMsgLen := 0
 	// if groupID != 0 {
-	oldMsgs := Msglog.GetMsgs(groupID, userID)
+	allMsgs := conv.history()
+	oldMsgs, cut := SelectHistory(allMsgs, TokenBudget(model))
 	if oldMsgs != nil {
 		oldMsgLen = len(oldMsgs)
 		for _, s := range oldMsgs {
@@ -92,6 +94,7 @@ func LmStudioText(message string, userID int64, groupID int64, botAdapterClient
 	defer func() {
 		if err == nil {
 			Msglog.AddMsg(groupID, userID, rsp, true, MsgTypeText, "")
+			compactHistory(groupID, userID, "lmstudio", allMsgs, cut, openaiSummarizer(newClient, model))
 		}
 	}()
 	for _, msg := range msgs {
@@ -113,7 +116,7 @@ func LmStudioText(message string, userID int64, groupID int64, botAdapterClient
 				}
 				f = fmt.Sprintf("data:%s;base64,%s", mimeType, base64.StdEncoding.EncodeToString(b))
 			} else if strings.HasPrefix(f, "file") {
-				img, err := botAdapterClient.GetImage(context.TODO(), &entity.GetImageReq{File: f})
+				img, err := botAdapterClient.GetImage(ctx, &entity.GetImageReq{File: f})
 				if err != nil {
 					return "", err
 				}
@@ -146,22 +149,22 @@ func LmStudioText(message string, userID int64, groupID int64, botAdapterClient
 		}
 	}
 	if len(aiMessages) == oldMsgLen {
-		return "", errors.New("empty")
+		return "", ErrEmpty
 	}
 	// 配置超时时间
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
 	defer cancel()
-	chatCompletion, err := newClient.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+	params := openai.ChatCompletionNewParams{
 		Messages: aiMessages,
-		Model:    shared.ChatModel(LmStudioModel),
+		Model:    shared.ChatModel(model),
 		// MaxTokens: openai.Int(1000),
-	},
-	)
+	}
+	if persona.Temperature != nil {
+		params.Temperature = openai.Float(*persona.Temperature)
+	}
+	completion, err := chatCompletion(ctx, newClient, params, conv, conf.Stream)
 	if err != nil {
 		return "", err
 	}
-	if len(chatCompletion.Choices) == 0 {
-		return "", errors.New("no choices returned")
-	}
-	return chatCompletion.Choices[0].Message.Content, nil
+	return completion.Content, nil
 }
@@ -0,0 +1,218 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 以 Prometheus 文本格式输出的监控指标，只实现了用到的 counter、gauge 和 histogram
+
+// metric 已注册的指标
+type metric interface {
+	write(w io.Writer)
+}
+
+var (
+	metricsLock sync.Mutex
+	metrics     []metric
+)
+
+func registerMetric(m metric) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+	metrics = append(metrics, m)
+}
+
+// WriteMetrics 按注册顺序输出所有指标
+func WriteMetrics(w io.Writer) {
+	metricsLock.Lock()
+	list := append([]metric(nil), metrics...)
+	metricsLock.Unlock()
+	for _, m := range list {
+		m.write(w)
+	}
+}
+
+// labelPairs 将标签名和值拼成 a="x",b="y"
+func labelPairs(names, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		v := ""
+		if i < len(values) {
+			v = values[i]
+		}
+		pairs[i] = fmt.Sprintf("%s=%s", name, strconv.Quote(v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counter 只增不减的计数，可以带标签
+type Counter struct {
+	name, help string
+	labels     []string
+	lock       sync.Mutex
+	values     map[string]float64
+}
+
+// NewCounter 创建并注册计数
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{name: name, help: help, labels: labels, values: make(map[string]float64)}
+	registerMetric(c)
+	return c
+}
+
+// Inc 计数加一，labelValues 与创建时的标签一一对应
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add 计数增加 v
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := labelPairs(c.labels, labelValues)
+	c.lock.Lock()
+	c.values[key] += v
+	c.lock.Unlock()
+}
+
+// Values 返回各标签组合的计数，key 为 a="x",b="y" 形式
+func (c *Counter) Values() map[string]float64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	ret := make(map[string]float64, len(c.values))
+	for k, v := range c.values {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	values := c.Values()
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "" {
+			fmt.Fprintf(w, "%s %s\n", c.name, formatFloat(values[k]))
+		} else {
+			fmt.Fprintf(w, "%s{%s} %s\n", c.name, k, formatFloat(values[k]))
+		}
+	}
+}
+
+// gaugeFunc 输出时调用函数取值的 gauge
+type gaugeFunc struct {
+	name, help string
+	labels     string
+	f          func() float64
+}
+
+// NewGaugeFunc 创建并注册 gauge，每次输出时调用 f 取值
+func NewGaugeFunc(name, help string, f func() float64) {
+	registerMetric(&gaugeFunc{name: name, help: help, f: f})
+}
+
+// NewInfo 注册值固定为 1 的 gauge，用标签输出版本等信息
+func NewInfo(name, help string, labels map[string]string) {
+	names := make([]string, 0, len(labels))
+	for k := range labels {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	values := make([]string, len(names))
+	for i, k := range names {
+		values[i] = labels[k]
+	}
+	registerMetric(&gaugeFunc{name: name, help: help, labels: labelPairs(names, values), f: func() float64 { return 1 }})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	if g.labels == "" {
+		fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.f()))
+	} else {
+		fmt.Fprintf(w, "%s{%s} %s\n", g.name, g.labels, formatFloat(g.f()))
+	}
+}
+
+// Histogram 分桶统计，可以带标签
+type Histogram struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+	lock       sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64 // 每个桶的累计数量
+	count  uint64
+	sum    float64
+}
+
+// NewHistogram 创建并注册分桶统计，buckets 需要从小到大排列
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{name: name, help: help, labels: labels, buckets: buckets, series: make(map[string]*histogramSeries)}
+	registerMetric(h)
+	return h
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := labelPairs(h.labels, labelValues)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, b := range h.buckets {
+		if v <= b {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += v
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.name, h.help, "histogram")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for k := range h.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := h.series[k]
+		prefix := k
+		if prefix != "" {
+			prefix += ","
+		}
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", h.name, prefix, formatFloat(b), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", h.name, prefix, s.count)
+		if k == "" {
+			fmt.Fprintf(w, "%s_sum %s\n%s_count %d\n", h.name, formatFloat(s.sum), h.name, s.count)
+		} else {
+			fmt.Fprintf(w, "%s_sum{%s} %s\n%s_count{%s} %d\n", h.name, k, formatFloat(s.sum), h.name, k, s.count)
+		}
+	}
+}
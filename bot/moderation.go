@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+)
+
+// 审核命中后的处理方式，可以同时配置多个
+const (
+	ModerationMask   = "mask"   // 将命中的关键词替换为 *，审核接口命中时无法定位内容，按 refuse 处理
+	ModerationRefuse = "refuse" // 拒绝提问或者不发送回复
+	ModerationNotify = "notify" // 私聊通知超级管理员
+)
+
+// ModerationActions 支持的处理方式
+var ModerationActions = []string{ModerationMask, ModerationRefuse, ModerationNotify}
+
+// ModerationResult 审核结果
+type ModerationResult struct {
+	Flagged bool
+	Text    string   // 处理后的文本，mask 时为替换后的内容
+	Reasons []string // 命中的关键词、正则或者审核接口的分类
+	Refuse  bool     // 是否需要拒绝
+	Notify  bool     // 是否需要通知管理员
+}
+
+// moderationRules 编译后的本地规则，配置热加载后重新编译
+type moderationRules struct {
+	conf     *Config
+	patterns []*regexp.Regexp
+}
+
+var rulesCache atomic.Pointer[moderationRules]
+
+// localRules 返回当前配置的关键词和正则，关键词不区分大小写
+func localRules() []*regexp.Regexp {
+	conf := Conf()
+	if r := rulesCache.Load(); r != nil && r.conf == conf {
+		return r.patterns
+	}
+	r := &moderationRules{conf: conf}
+	for _, k := range conf.Moderation.Keywords {
+		if k = strings.TrimSpace(k); k != "" {
+			r.patterns = append(r.patterns, regexp.MustCompile("(?i)"+regexp.QuoteMeta(k)))
+		}
+	}
+	for _, p := range conf.Moderation.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			// 配置校验时已经检查过，这里只是防御
+			log.Warnf("忽略无效的审核正则 %q: %v", p, err)
+			continue
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	rulesCache.Store(r)
+	return r.patterns
+}
+
+// ModerateInput 审核用户的提问，只审核 CQ 码之间的文字
+func ModerateInput(ctx context.Context, text string) ModerationResult {
+	return moderate(ctx, text, Conf().Moderation.InputActions, true)
+}
+
+// ModerateOutput 审核发送前的回复，模型的回复是纯文本
+func ModerateOutput(ctx context.Context, text string) ModerationResult {
+	return moderate(ctx, text, Conf().Moderation.OutputActions, false)
+}
+
+func moderate(ctx context.Context, text string, actions []string, cq bool) ModerationResult {
+	res := ModerationResult{Text: text}
+	if len(actions) == 0 {
+		return res
+	}
+	mask := false
+	for _, a := range actions {
+		switch a {
+		case ModerationMask:
+			mask = true
+		case ModerationRefuse:
+			res.Refuse = true
+		case ModerationNotify:
+			res.Notify = true
+		}
+	}
+	var plain []string
+	check := func(s string) string {
+		if cq {
+			s = cqUnescape.Replace(s)
+		}
+		for _, re := range localRules() {
+			matches := re.FindAllString(s, -1)
+			if len(matches) == 0 {
+				continue
+			}
+			res.Flagged = true
+			res.Reasons = append(res.Reasons, matches...)
+			if mask {
+				s = re.ReplaceAllStringFunc(s, func(m string) string {
+					return strings.Repeat("*", utf8.RuneCountInString(m))
+				})
+			}
+		}
+		plain = append(plain, s)
+		if cq {
+			s = cqEscape.Replace(s)
+		}
+		return s
+	}
+	var masked string
+	if cq {
+		// 只审核 CQ 码之间的文字，图片的文件名、链接和 @ 的号码不参与匹配，也不会被 mask 破坏
+		masked = replaceText(text, check)
+	} else {
+		masked = check(text)
+	}
+	if mask {
+		res.Text = masked
+	}
+	if categories := moderateRemote(ctx, strings.Join(plain, "")); len(categories) > 0 {
+		res.Flagged = true
+		res.Reasons = append(res.Reasons, categories...)
+		if mask {
+			res.Refuse = true
+		}
+	}
+	if !res.Flagged {
+		return ModerationResult{Text: text}
+	}
+	return res
+}
+
+var (
+	// cqCodeReg 消息中的 CQ 码
+	cqCodeReg = regexp.MustCompile(`\[CQ:[^\]]*\]`)
+	// CQ 码之外的文字中转义的字符
+	cqUnescape = strings.NewReplacer("&#91;", "[", "&#93;", "]", "&#44;", ",", "&amp;", "&")
+	cqEscape   = strings.NewReplacer("&", "&amp;", "[", "&#91;", "]", "&#93;")
+)
+
+// replaceText 用 f 处理消息中 CQ 码之间的文字，CQ 码保持不变
+func replaceText(message string, f func(string) string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range cqCodeReg.FindAllStringIndex(message, -1) {
+		if loc[0] > last {
+			b.WriteString(f(message[last:loc[0]]))
+		}
+		b.WriteString(message[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	if last < len(message) {
+		b.WriteString(f(message[last:]))
+	}
+	return b.String()
+}
+
+// moderateRemote 调用 openai 兼容的审核接口，返回命中的分类，接口出错时不拦截
+func moderateRemote(ctx context.Context, text string) []string {
+	conf := Conf().Moderation
+	if conf.Endpoint == "" || strings.TrimSpace(text) == "" {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	c := openai.NewClient(
+		option.WithBaseURL(conf.Endpoint),
+		option.WithAPIKey(conf.APIKey),
+		option.WithHTTPClient(NewHTTPClient(10*time.Second)),
+	)
+	resp, err := c.Moderations.New(ctx, openai.ModerationNewParams{
+		Input: openai.ModerationNewParamsInputUnion{OfString: openai.String(text)},
+		Model: openai.ModerationModel(conf.Model),
+	})
+	if err != nil {
+		log.Errorf("调用审核接口失败 err:%v", err)
+		return nil
+	}
+	var categories []string
+	for _, r := range resp.Results {
+		if !r.Flagged {
+			continue
+		}
+		gjson.Parse(r.Categories.RawJSON()).ForEach(func(key, value gjson.Result) bool {
+			if value.Bool() {
+				categories = append(categories, key.String())
+			}
+			return true
+		})
+		if len(categories) == 0 {
+			categories = append(categories, "flagged")
+		}
+	}
+	return categories
+}
@@ -0,0 +1,32 @@
+package bot
+
+import (
+	"context"
+	"testing"
+)
+
+func TestModerateInput(t *testing.T) {
+	conf := DefaultConfig()
+	conf.Moderation.Keywords = []string{"坏词", "bad"}
+	conf.Moderation.Patterns = []string{`1[3-9]\d{9}`}
+	conf.Moderation.InputActions = []string{ModerationMask}
+	SetConfig(conf)
+	tests := []struct {
+		message string
+		want    string
+		flagged bool
+	}{
+		{"你好", "你好", false},
+		{"这是坏词", "这是**", true},
+		{"[CQ:image,file=13800138000abc.jpg]看图", "[CQ:image,file=13800138000abc.jpg]看图", false},
+		{"[CQ:at,qq=13800138000] 我的号码 13800138000", "[CQ:at,qq=13800138000] 我的号码 ***********", true},
+		{"BAD &#91;bad&#93;[CQ:face,id=1]", "*** &#91;***&#93;[CQ:face,id=1]", true},
+		{"[CQ:image,file=bad.png,url=http://x/bad]", "[CQ:image,file=bad.png,url=http://x/bad]", false},
+	}
+	for _, tt := range tests {
+		res := ModerateInput(context.Background(), tt.message)
+		if res.Text != tt.want || res.Flagged != tt.flagged {
+			t.Errorf("ModerateInput(%q) = %q, %v, want %q, %v", tt.message, res.Text, res.Flagged, tt.want, tt.flagged)
+		}
+	}
+}
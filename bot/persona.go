@@ -0,0 +1,151 @@
+package bot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultPersonaName 默认人设的名称
+const DefaultPersonaName = "default"
+
+// Persona 人设，决定系统提示词以及可选的温度和模型
+type Persona struct {
+	Name        string   `json:"name"`
+	Prompt      string   `json:"prompt"`
+	Temperature *float64 `json:"temperature,omitempty"`
+	Model       string   `json:"model,omitempty"` // 提供者:模型，为空时使用提供者自己的模型配置
+}
+
+// ModelFor 返回人设为提供者指定的模型，未指定或者指定给其他提供者时返回 def
+// 没有写提供者的模型只用于 openai，避免回退到其他提供者时请求不存在的模型
+func (p *Persona) ModelFor(provider, def string) string {
+	if p.Model == "" {
+		return def
+	}
+	if name, model, ok := strings.Cut(p.Model, ":"); ok && GetProvider(name) != nil {
+		if name == provider {
+			return model
+		}
+		return def
+	}
+	if provider == "openai" {
+		return p.Model
+	}
+	return def
+}
+
+// personaSet 一个群或私聊保存的全部人设
+type personaSet struct {
+	Active   string              `json:"active"`
+	Personas map[string]*Persona `json:"personas"`
+}
+
+// personaKey 群聊的人设按群保存，私聊按用户保存
+func personaKey(groupID, userID int64) string {
+	if groupID != 0 {
+		return fmt.Sprintf("@persona/group/%d", groupID)
+	}
+	return fmt.Sprintf("@persona/user/%d", userID)
+}
+
+// DefaultPersona 返回默认人设，提示词来自 chat.default_prompt
+func DefaultPersona() *Persona {
+	return &Persona{Name: DefaultPersonaName, Prompt: Conf().Chat.DefaultPrompt}
+}
+
+func getPersonaSet(groupID, userID int64) *personaSet {
+	set := &personaSet{Personas: make(map[string]*Persona)}
+	buf, err := Msglog.db.Get([]byte(personaKey(groupID, userID)), nil)
+	if err != nil {
+		return set
+	}
+	_ = json.Unmarshal(buf, set)
+	if set.Personas == nil {
+		set.Personas = make(map[string]*Persona)
+	}
+	return set
+}
+
+func putPersonaSet(groupID, userID int64, set *personaSet) error {
+	buf, _ := json.Marshal(set)
+	return Msglog.db.Put([]byte(personaKey(groupID, userID)), buf, nil)
+}
+
+// ActivePersona 返回对话当前使用的人设
+func ActivePersona(groupID, userID int64) *Persona {
+	set := getPersonaSet(groupID, userID)
+	if p, ok := set.Personas[set.Active]; ok {
+		return p
+	}
+	return DefaultPersona()
+}
+
+// GetPersona 根据名称获取已保存的人设，不存在时返回 nil
+func GetPersona(groupID, userID int64, name string) *Persona {
+	return getPersonaSet(groupID, userID).Personas[name]
+}
+
+// ListPersonas 返回对话保存的所有人设和当前使用的人设名称
+func ListPersonas(groupID, userID int64) (string, []*Persona) {
+	set := getPersonaSet(groupID, userID)
+	active := set.Active
+	if _, ok := set.Personas[active]; !ok {
+		active = DefaultPersonaName
+	}
+	list := make([]*Persona, 0, len(set.Personas)+1)
+	list = append(list, DefaultPersona())
+	for _, p := range set.Personas {
+		list = append(list, p)
+	}
+	sort.Slice(list[1:], func(i, j int) bool {
+		return list[i+1].Name < list[j+1].Name
+	})
+	return active, list
+}
+
+// SavePersona 保存人设并切换为当前使用的人设
+func SavePersona(groupID, userID int64, p *Persona) error {
+	if p.Name == "" || p.Name == DefaultPersonaName {
+		return errors.New("不能覆盖默认人设")
+	}
+	if p.Prompt == "" {
+		return errors.New("提示词不能为空")
+	}
+	set := getPersonaSet(groupID, userID)
+	set.Personas[p.Name] = p
+	set.Active = p.Name
+	return putPersonaSet(groupID, userID, set)
+}
+
+// UsePersona 切换到已保存的人设
+func UsePersona(groupID, userID int64, name string) error {
+	set := getPersonaSet(groupID, userID)
+	if name != DefaultPersonaName {
+		if _, ok := set.Personas[name]; !ok {
+			return fmt.Errorf("人设 %s 不存在", name)
+		}
+	}
+	set.Active = name
+	return putPersonaSet(groupID, userID, set)
+}
+
+// DeletePersona 删除已保存的人设，删除当前人设后恢复默认
+func DeletePersona(groupID, userID int64, name string) error {
+	set := getPersonaSet(groupID, userID)
+	if _, ok := set.Personas[name]; !ok {
+		return fmt.Errorf("人设 %s 不存在", name)
+	}
+	delete(set.Personas, name)
+	if set.Active == name {
+		set.Active = DefaultPersonaName
+	}
+	return putPersonaSet(groupID, userID, set)
+}
+
+// ResetPersona 恢复默认人设，已保存的人设不会被删除
+func ResetPersona(groupID, userID int64) error {
+	return UsePersona(groupID, userID, DefaultPersonaName)
+}
@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/scjtqs2/bot_adapter/client"
+)
+
+// ErrEmpty 消息中没有可以交给 AI 处理的内容
+var ErrEmpty = errors.New("empty")
+
+// Capability 提供者能力标记
+type Capability uint
+
+// 提供者能力
+const (
+	CapImage   Capability = 1 << iota // 支持图片输入
+	CapHistory                        // 使用 Msglog 中的历史消息
+	CapStream                         // 支持流式输出
+)
+
+// Has 判断是否具备某项能力
+func (c Capability) Has(flag Capability) bool {
+	return c&flag != 0
+}
+
+// Conversation 一次对话请求
+type Conversation struct {
+	Message string // 已去掉触发前缀的消息内容
+	UserID  int64
+	GroupID int64
+	SelfID  int64
+	IsGroup bool
+	// MessageID 触发对话的消息的 message_id，用于引用回复
+	MessageID int64
+	// Sender 发送者的群名片或昵称，共享上下文时用于标注发言人
+	Sender string
+	// ChimeIn 随机插话，没有人要求机器人回复，不扣除限流的令牌，限流、额度用完或者审核不通过时不回复任何提示
+	ChimeIn bool
+	Client  *client.AdapterService
+	// Stream 流式输出时用于发送已完成的段落，为 nil 时不使用流式输出
+	Stream func(text string)
+	// ReplaceTurn 重新生成时被替换的一轮问答的序号，生成时不作为上下文，回答成功后删除
+	ReplaceTurn int64
+	// usage 本次回复的 token 用量，由 CallProvider 清零并写入审计记录
+	usage Usage
+}
+
+// history 读取对话的历史消息，重新生成时不包括被替换的一轮
+func (c *Conversation) history() []MsgObj {
+	msgs := Msglog.GetMsgs(c.GroupID, c.UserID)
+	if c.ReplaceTurn == 0 {
+		return msgs
+	}
+	msgs, _ = withoutTurn(msgs, c.ReplaceTurn)
+	return msgs
+}
+
+// Provider AI 聊天提供者
+type Provider interface {
+	// Name 提供者名称，例如 openai
+	Name() string
+	// Capabilities 提供者支持的能力
+	Capabilities() Capability
+	// Enabled 是否已经完成配置可以使用
+	Enabled() bool
+	// Reply 根据对话内容生成回复
+	Reply(ctx context.Context, conv *Conversation) (string, error)
+}
+
+type registeredProvider struct {
+	priority int
+	provider Provider
+}
+
+var (
+	providersLock sync.RWMutex
+	providers     []registeredProvider
+)
+
+// RegisterProvider 注册提供者，priority 越小越优先
+func RegisterProvider(priority int, p Provider) {
+	providersLock.Lock()
+	defer providersLock.Unlock()
+	providers = append(providers, registeredProvider{priority: priority, provider: p})
+	sort.SliceStable(providers, func(i, j int) bool {
+		return providers[i].priority < providers[j].priority
+	})
+}
+
+// Providers 按优先级返回所有已注册的提供者
+func Providers() []Provider {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	ret := make([]Provider, 0, len(providers))
+	for _, p := range providers {
+		ret = append(ret, p.provider)
+	}
+	return ret
+}
+
+// GetProvider 根据名称获取提供者
+func GetProvider(name string) Provider {
+	providersLock.RLock()
+	defer providersLock.RUnlock()
+	for _, p := range providers {
+		if p.provider.Name() == name {
+			return p.provider
+		}
+	}
+	return nil
+}
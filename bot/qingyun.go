@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -19,6 +20,23 @@ import (
 var qingyunkeKey = "free"
 var qingyunkeAPI = "http://api.qingyunke.com/api.php"
 
+func init() {
+	RegisterProvider(50, qingyunkeProvider{})
+}
+
+// qingyunkeProvider 青云客机器人提供者，免费接口，作为最后的兜底
+type qingyunkeProvider struct{}
+
+func (qingyunkeProvider) Name() string { return "qingyunke" }
+
+func (qingyunkeProvider) Capabilities() Capability { return 0 }
+
+func (qingyunkeProvider) Enabled() bool { return true }
+
+func (qingyunkeProvider) Reply(_ context.Context, conv *Conversation) (string, error) {
+	return QingyunkeText(conv.Message, conv.UserID, conv.GroupID)
+}
+
 // QingyunkeText 文字
 func QingyunkeText(message string, _ int64, _ int64) (string, error) {
 	msg := coolq.CleanCQCode(message)
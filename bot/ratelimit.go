@@ -0,0 +1,158 @@
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 限流的范围
+const (
+	LimitScopeUser   = "user"
+	LimitScopeGroup  = "group"
+	LimitScopeGlobal = "global"
+)
+
+// Limit 令牌桶参数，Rate 为每分钟补充的次数，为 0 时不限制
+type Limit struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"` // 桶的容量，即允许连续请求的次数
+}
+
+// LimitSet 按用户、群和全局的限流
+type LimitSet struct {
+	User   Limit `yaml:"user"`
+	Group  Limit `yaml:"group"`
+	Global Limit `yaml:"global"`
+}
+
+// ParseLimit 解析 每分钟次数,容量 格式的限流参数，例如 RATE_LIMIT_USER=10,5
+func ParseLimit(s string) (Limit, error) {
+	rate, burst, _ := strings.Cut(s, ",")
+	r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	if err != nil {
+		return Limit{}, fmt.Errorf("invalid rate %q", rate)
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(burst))
+	if err != nil {
+		return Limit{}, fmt.Errorf("invalid burst %q", burst)
+	}
+	return Limit{Rate: r, Burst: b}, nil
+}
+
+// bucket 令牌桶，令牌数在取用时按经过的时间补充
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill 按限流参数补充令牌，配置热加载后容量变小时截断
+func (b *bucket) refill(l Limit, now time.Time) {
+	b.tokens = math.Min(float64(l.Burst), b.tokens+now.Sub(b.last).Minutes()*l.Rate)
+	b.last = now
+}
+
+var limiter = struct {
+	lock    sync.Mutex
+	buckets map[string]*bucket
+	notices map[string]time.Time // 每个对话最近一次限流提示的时间
+}{
+	buckets: make(map[string]*bucket),
+	notices: make(map[string]time.Time),
+}
+
+// AllowRequest 检查并扣除用户、群和全局的令牌，provider 为空时使用默认限流，否则使用该提供者的限流
+// 任意一个范围的令牌不足时不扣除，返回不足的范围
+func AllowRequest(provider string, groupID, userID int64) (string, bool) {
+	return checkRequest(provider, groupID, userID, true)
+}
+
+// PeekRequest 和 AllowRequest 一样检查令牌，但是不扣除，用于随机插话
+func PeekRequest(provider string, groupID, userID int64) (string, bool) {
+	return checkRequest(provider, groupID, userID, false)
+}
+
+func checkRequest(provider string, groupID, userID int64, take bool) (string, bool) {
+	conf := Conf().RateLimit
+	set := conf.LimitSet
+	prefix := "*"
+	if provider != "" {
+		var ok bool
+		if set, ok = conf.Providers[provider]; !ok {
+			return "", true
+		}
+		prefix = provider
+	}
+	type scoped struct {
+		scope, key string
+		limit      Limit
+	}
+	scopes := []scoped{{LimitScopeUser, fmt.Sprintf("%s/user/%d", prefix, userID), set.User}}
+	if groupID != 0 {
+		scopes = append(scopes, scoped{LimitScopeGroup, fmt.Sprintf("%s/group/%d", prefix, groupID), set.Group})
+	}
+	scopes = append(scopes, scoped{LimitScopeGlobal, prefix + "/global", set.Global})
+
+	now := time.Now()
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if len(limiter.buckets) > 10000 {
+		cleanBuckets(now)
+	}
+	var taken []*bucket
+	for _, s := range scopes {
+		if s.limit.Rate <= 0 {
+			continue
+		}
+		b, ok := limiter.buckets[s.key]
+		if !ok {
+			b = &bucket{tokens: float64(s.limit.Burst), last: now}
+			limiter.buckets[s.key] = b
+		}
+		b.refill(s.limit, now)
+		if b.tokens < 1 {
+			return s.scope, false
+		}
+		if take {
+			taken = append(taken, b)
+		}
+	}
+	for _, b := range taken {
+		b.tokens--
+	}
+	return "", true
+}
+
+// cleanBuckets 删除一小时没有使用的桶，通常已经补满，删除后重新创建的效果相同
+func cleanBuckets(now time.Time) {
+	for k, b := range limiter.buckets {
+		if now.Sub(b.last) > time.Hour {
+			delete(limiter.buckets, k)
+		}
+	}
+	for k, t := range limiter.notices {
+		if now.Sub(t) > time.Hour {
+			delete(limiter.notices, k)
+		}
+	}
+}
+
+// ThrottleNotice 是否需要发送限流提示，群聊每个群、私聊每个用户在 notice_interval 内只提示一次
+func ThrottleNotice(groupID, userID int64) bool {
+	key := fmt.Sprintf("user/%d", userID)
+	if groupID != 0 {
+		key = fmt.Sprintf("group/%d", groupID)
+	}
+	interval := time.Duration(Conf().RateLimit.NoticeInterval) * time.Second
+	now := time.Now()
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+	if t, ok := limiter.notices[key]; ok && now.Sub(t) < interval {
+		return false
+	}
+	limiter.notices[key] = now
+	return true
+}
@@ -0,0 +1,93 @@
+package bot
+
+import "testing"
+
+// setLimits 使用给定的限流配置并清空已有的令牌桶
+func setLimits(t *testing.T, set LimitSet, providers map[string]LimitSet) {
+	t.Helper()
+	conf := DefaultConfig()
+	conf.RateLimit.LimitSet = set
+	conf.RateLimit.Providers = providers
+	SetConfig(conf)
+	limiter.lock.Lock()
+	clear(limiter.buckets)
+	limiter.lock.Unlock()
+}
+
+func TestAllowRequest(t *testing.T) {
+	setLimits(t, LimitSet{
+		User:  Limit{Rate: 1, Burst: 2},
+		Group: Limit{Rate: 1, Burst: 1},
+	}, map[string]LimitSet{
+		"openai": {Global: Limit{Rate: 1, Burst: 1}},
+	})
+	tests := []struct {
+		provider string
+		group    int64
+		user     int64
+		scope    string
+		ok       bool
+	}{
+		{"", 100, 1, "", true},
+		// 群的令牌不足时不扣除用户的令牌
+		{"", 100, 1, LimitScopeGroup, false},
+		{"", 200, 1, "", true},
+		{"", 300, 1, LimitScopeUser, false},
+		{"", 0, 2, "", true},
+		{"", 0, 2, "", true},
+		{"", 0, 2, LimitScopeUser, false},
+		// 提供者的限流和默认限流分开计算
+		{"openai", 100, 1, "", true},
+		{"openai", 200, 3, LimitScopeGlobal, false},
+		{"gemini", 100, 1, "", true},
+	}
+	for i, tt := range tests {
+		scope, ok := AllowRequest(tt.provider, tt.group, tt.user)
+		if scope != tt.scope || ok != tt.ok {
+			t.Errorf("#%d AllowRequest(%q, %d, %d) = %q, %v, want %q, %v", i, tt.provider, tt.group, tt.user, scope, ok, tt.scope, tt.ok)
+		}
+	}
+}
+
+func TestPeekRequest(t *testing.T) {
+	setLimits(t, LimitSet{User: Limit{Rate: 1, Burst: 1}}, nil)
+	for i := 0; i < 3; i++ {
+		if _, ok := PeekRequest("", 0, 1); !ok {
+			t.Fatalf("peek %d limited", i)
+		}
+	}
+	if _, ok := AllowRequest("", 0, 1); !ok {
+		t.Fatal("PeekRequest took a token")
+	}
+	if scope, ok := PeekRequest("", 0, 1); ok || scope != LimitScopeUser {
+		t.Errorf("PeekRequest after the bucket is empty = %q, %v, want %q, false", scope, ok, LimitScopeUser)
+	}
+}
+
+func TestAllowRequestUnlimited(t *testing.T) {
+	setLimits(t, LimitSet{User: Limit{Rate: 0, Burst: 1}}, nil)
+	for i := 0; i < 10; i++ {
+		if _, ok := AllowRequest("", 0, 1); !ok {
+			t.Fatalf("request %d limited with rate 0", i)
+		}
+	}
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Limit
+		ok   bool
+	}{
+		{"10,5", Limit{Rate: 10, Burst: 5}, true},
+		{" 0.5 , 1 ", Limit{Rate: 0.5, Burst: 1}, true},
+		{"10", Limit{}, false},
+		{"a,5", Limit{}, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimit(tt.s)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("ParseLimit(%q) = %+v, %v, want %+v, ok %v", tt.s, got, err, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ParseChain 解析逗号或空格分隔的提供者名称列表
+func ParseChain(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '>' || r == '\n'
+	})
+	chain := make([]string, 0, len(fields))
+	for _, f := range fields {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f != "" {
+			chain = append(chain, f)
+		}
+	}
+	return chain
+}
+
+// ValidateChain 校验提供者名称是否都已注册
+func ValidateChain(chain []string) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("提供者列表为空")
+	}
+	for _, name := range chain {
+		if GetProvider(name) == nil {
+			return fmt.Errorf("未知的提供者: %s", name)
+		}
+	}
+	return nil
+}
+
+func groupRouteKey(groupID int64) string {
+	return fmt.Sprintf("@route/group/%d", groupID)
+}
+
+func userRouteKey(userID int64) string {
+	return fmt.Sprintf("@route/user/%d", userID)
+}
+
+func getRoute(key string) []string {
+	buf, err := Msglog.db.Get([]byte(key), nil)
+	if err != nil {
+		return nil
+	}
+	var chain []string
+	_ = json.Unmarshal(buf, &chain)
+	return chain
+}
+
+func setRoute(key string, chain []string) error {
+	if chain == nil {
+		return Msglog.db.Delete([]byte(key), nil)
+	}
+	if err := ValidateChain(chain); err != nil {
+		return err
+	}
+	buf, _ := json.Marshal(chain)
+	return Msglog.db.Put([]byte(key), buf, nil)
+}
+
+// GetGroupRoute 获取群的提供者覆盖配置
+func GetGroupRoute(groupID int64) []string {
+	return getRoute(groupRouteKey(groupID))
+}
+
+// SetGroupRoute 设置群的提供者覆盖配置，chain 为 nil 时删除
+func SetGroupRoute(groupID int64, chain []string) error {
+	return setRoute(groupRouteKey(groupID), chain)
+}
+
+// GetUserRoute 获取用户的提供者覆盖配置
+func GetUserRoute(userID int64) []string {
+	return getRoute(userRouteKey(userID))
+}
+
+// SetUserRoute 设置用户的提供者覆盖配置，chain 为 nil 时删除
+func SetUserRoute(userID int64, chain []string) error {
+	return setRoute(userRouteKey(userID), chain)
+}
+
+// DefaultChain 返回默认的提供者顺序
+func DefaultChain() []Provider {
+	chain := Conf().Chat.ProviderChain
+	if len(chain) == 0 {
+		return Providers()
+	}
+	ret := make([]Provider, 0, len(chain))
+	for _, name := range chain {
+		if p := GetProvider(name); p != nil {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+// Route 返回对话应使用的提供者链
+// 群聊依次使用 群覆盖 > 用户覆盖 > 默认链，私聊使用 用户覆盖 > 默认链，重复的提供者只保留第一次出现
+func Route(groupID, userID int64) []Provider {
+	var names []string
+	if groupID != 0 {
+		names = append(names, GetGroupRoute(groupID)...)
+	}
+	names = append(names, GetUserRoute(userID)...)
+	for _, p := range DefaultChain() {
+		names = append(names, p.Name())
+	}
+	seen := make(map[string]bool, len(names))
+	chain := make([]Provider, 0, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if p := GetProvider(name); p != nil {
+			chain = append(chain, p)
+		}
+	}
+	return chain
+}
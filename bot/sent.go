@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// 机器人在群里发出的消息的前缀，key 为 @sent/<群号>/<message_id>
+const sentPrefix = "@sent/"
+
+// sentTTL 发出的消息保存的时间，超过后引用这些消息不再触发机器人
+const sentTTL = 7 * 24 * time.Hour
+
+// sentMsg 发出的消息
+type sentMsg struct {
+	Text   string `json:"text"`
+	Expire int64  `json:"expire"` // 过期时间的 unix 秒
+}
+
+var (
+	sentLock sync.Mutex
+	// sentCount 记录次数，每记录一定次数清理一次过期的记录
+	sentCount int
+)
+
+func sentKey(groupID, messageID int64) []byte {
+	return []byte(fmt.Sprintf("%s%d/%d", sentPrefix, groupID, messageID))
+}
+
+// RecordSent 记录机器人在群里发出的消息，用户引用这些消息时可以找到原文
+func RecordSent(groupID, messageID int64, text string) {
+	buf, _ := json.Marshal(sentMsg{Text: text, Expire: time.Now().Add(sentTTL).Unix()})
+	if err := Msglog.db.Put(sentKey(groupID, messageID), buf, nil); err != nil {
+		log.Errorf("记录发出的消息失败 err:%v", err)
+	}
+	sentLock.Lock()
+	defer sentLock.Unlock()
+	sentCount++
+	if sentCount >= 1000 {
+		sentCount = 0
+		cleanSent(time.Now())
+	}
+}
+
+// SentText 查询群里的消息是否由机器人发出，返回消息原文
+func SentText(groupID, messageID int64) (string, bool) {
+	buf, err := Msglog.db.Get(sentKey(groupID, messageID), nil)
+	if err != nil {
+		return "", false
+	}
+	var m sentMsg
+	if json.Unmarshal(buf, &m) != nil || m.Expire <= time.Now().Unix() {
+		return "", false
+	}
+	return m.Text, true
+}
+
+// cleanSent 删除过期的记录
+func cleanSent(now time.Time) {
+	iter := Msglog.db.NewIterator(util.BytesPrefix([]byte(sentPrefix)), nil)
+	defer iter.Release()
+	removed := 0
+	for iter.Next() {
+		var m sentMsg
+		if json.Unmarshal(iter.Value(), &m) == nil && m.Expire > now.Unix() {
+			continue
+		}
+		_ = Msglog.db.Delete(iter.Key(), nil)
+		removed++
+	}
+	log.Debugf("清理过期的发出消息记录 %d 条", removed)
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/openai/openai-go"
+)
+
+// chunker 将流式输出按段落/句子切分，凑够最少字数后再发送
+type chunker struct {
+	buf  strings.Builder
+	min  int
+	emit func(text string)
+}
+
+func newChunker(minChunk int, emit func(text string)) *chunker {
+	return &chunker{min: minChunk, emit: emit}
+}
+
+// Write 追加增量内容，遇到合适的断句位置就发送
+func (c *chunker) Write(delta string) {
+	if delta == "" {
+		return
+	}
+	c.buf.WriteString(delta)
+	text := c.buf.String()
+	idx := c.boundary(text)
+	if idx <= 0 {
+		return
+	}
+	c.send(text[:idx])
+	c.buf.Reset()
+	c.buf.WriteString(text[idx:])
+}
+
+// Flush 发送剩余的全部内容
+func (c *chunker) Flush() {
+	c.send(c.buf.String())
+	c.buf.Reset()
+}
+
+func (c *chunker) send(text string) {
+	text = strings.TrimSpace(text)
+	if text != "" {
+		c.emit(text)
+	}
+}
+
+// boundary 返回最后一个满足最少字数的断句位置，没有则返回 -1
+func (c *chunker) boundary(text string) int {
+	last := -1
+	count := 0
+	for i, r := range text {
+		count++
+		end := i + utf8.RuneLen(r)
+		switch r {
+		case '\n', '。', '！', '？', '；', '!', '?', ';':
+		case '.':
+			// 英文句号后需要跟空白，避免把小数和网址切开
+			if end >= len(text) || (text[end] != ' ' && text[end] != '\n') {
+				continue
+			}
+		default:
+			continue
+		}
+		if count >= c.min {
+			last = end
+		}
+	}
+	return last
+}
+
+// chatCompletion 调用 openai 兼容接口，stream 为 true 且对话支持流式发送时按句子增量推送
+func chatCompletion(ctx context.Context, c openai.Client, params openai.ChatCompletionNewParams, conv *Conversation, stream bool) (openai.ChatCompletionMessage, error) {
+	if !stream || conv.Stream == nil {
+		resp, err := c.Chat.Completions.New(ctx, params)
+		if err != nil {
+			return openai.ChatCompletionMessage{}, err
+		}
+		conv.addUsage(params.Model, resp.Usage.PromptTokens, resp.Usage.CompletionTokens)
+		if len(resp.Choices) == 0 {
+			return openai.ChatCompletionMessage{}, errors.New("no choices returned")
+		}
+		return resp.Choices[0].Message, nil
+	}
+	// 流式输出默认不返回用量，需要在最后一个 chunk 中附带
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+	s := c.Chat.Completions.NewStreaming(ctx, params)
+	defer func() { _ = s.Close() }()
+	acc := openai.ChatCompletionAccumulator{}
+	ck := newChunker(Conf().Chat.StreamMinChunk, conv.Stream)
+	for s.Next() {
+		chunk := s.Current()
+		acc.AddChunk(chunk)
+		if len(chunk.Choices) > 0 {
+			ck.Write(chunk.Choices[0].Delta.Content)
+		}
+	}
+	// 出错时也把已经生成的内容发出去，避免用户只看到半句话
+	ck.Flush()
+	conv.addUsage(params.Model, acc.Usage.PromptTokens, acc.Usage.CompletionTokens)
+	if err := s.Err(); err != nil {
+		return openai.ChatCompletionMessage{}, err
+	}
+	if len(acc.Choices) == 0 {
+		return openai.ChatCompletionMessage{}, errors.New("no choices returned")
+	}
+	return acc.Choices[0].Message, nil
+}
+
+// openaiSummarizer 使用 openai 兼容接口生成摘要
+func openaiSummarizer(c openai.Client, model string) summarizeFunc {
+	return func(ctx context.Context, prompt string) (string, Usage, error) {
+		resp, err := c.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+			Messages: []openai.ChatCompletionMessageParamUnion{openai.UserMessage(prompt)},
+			Model:    model,
+		})
+		if err != nil {
+			return "", Usage{Model: model}, err
+		}
+		usage := Usage{Model: model, PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+		if len(resp.Choices) == 0 {
+			return "", usage, errors.New("no choices returned")
+		}
+		return resp.Choices[0].Message.Content, usage, nil
+	}
+}
@@ -0,0 +1,44 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChunkerBoundary(t *testing.T) {
+	tests := []struct {
+		text string
+		min  int
+		want int
+	}{
+		{"你好。", 5, -1},
+		{"你好世界啊。再见", 5, len("你好世界啊。")},
+		{"一二三四五。六七八九十。尾巴", 5, len("一二三四五。六七八九十。")},
+		{"短。一二三四五六七八", 5, -1},
+		{"第一行\n第二行", 1, len("第一行\n")},
+		{"Hello. World", 1, len("Hello.")},
+		{"pi is 3.14 ok", 1, -1},
+		{"see example.com now", 1, -1},
+		{"end.", 1, -1},
+		{"Done!Next", 1, len("Done!")},
+	}
+	for _, tt := range tests {
+		c := newChunker(tt.min, nil)
+		if got := c.boundary(tt.text); got != tt.want {
+			t.Errorf("boundary(%q, min=%d) = %d, want %d", tt.text, tt.min, got, tt.want)
+		}
+	}
+}
+
+func TestChunkerWrite(t *testing.T) {
+	var got []string
+	c := newChunker(5, func(text string) { got = append(got, text) })
+	for _, delta := range []string{"一二", "三四五。六", "", "七。", " 3.", "14 结束"} {
+		c.Write(delta)
+	}
+	c.Flush()
+	want := []string{"一二三四五。", "六七。 3.14 结束"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %q, want %q", got, want)
+	}
+}
@@ -3,8 +3,10 @@ package bot
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/syndtr/goleveldb/leveldb"
 	"sync"
+	"time"
+
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 // Msglog 全局消息日志实例
@@ -15,35 +17,61 @@ type MSG map[string]interface{}
 
 // MsgLog 消息日志结构
 type MsgLog struct {
-	db    *leveldb.DB
-	lock  sync.Mutex
-	lenth int
+	db   *leveldb.DB
+	lock sync.Mutex
 }
 
 // 消息类型常量
 const (
-	MsgTypeText  = "" // 默认为空，兼容之前的
-	MsgTypeImage = "image"
+	MsgTypeText       = "" // 默认为空，兼容之前的
+	MsgTypeImage      = "image"
+	MsgTypeToolCall   = "tool_call"   // 模型发起的工具调用，Msg 为调用列表的 json
+	MsgTypeToolResult = "tool_result" // 工具调用的结果
 )
 
 // MsgObj 消息对象
 type MsgObj struct {
-	IsSystem bool   `json:"is_system"`
-	Msg      string `json:"msg"`
-	MsgType  string `json:"msg_type"`  // 消息类型
-	MimeType string `json:"mime_type"` // 图片类型
+	IsSystem   bool   `json:"is_system"`
+	Msg        string `json:"msg"`
+	MsgType    string `json:"msg_type"`               // 消息类型
+	MimeType   string `json:"mime_type"`              // 图片类型
+	ToolCallID string `json:"tool_call_id,omitempty"` // 工具调用结果对应的调用 ID
+	Seq        int64  `json:"seq,omitempty"`          // 写入时分配的递增序号，用于识别消息，旧数据为 0
 }
 
-func init() {
-	db, err := leveldb.OpenFile("/data/msgdb", nil)
+// OpenMsgLog 打开消息数据库，需要在使用 Msglog 之前调用
+func OpenMsgLog(path string) error {
+	db, err := leveldb.OpenFile(path, nil)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	Msglog = &MsgLog{db: db}
+	return nil
+}
+
+// Close 关闭数据库
+func (m *MsgLog) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.db.Close()
+}
+
+// Ping 检查数据库是否可用
+func (m *MsgLog) Ping() error {
+	_, err := m.db.Get([]byte("@ping"), nil)
+	if err == leveldb.ErrNotFound {
+		return nil
 	}
-	Msglog = &MsgLog{db: db, lenth: 30}
+	return err
 }
 
 // AddMsg 添加消息
 func (m *MsgLog) AddMsg(groupid, userid int64, text string, isSystem bool, msgType string, mimeType string) {
+	m.AddObj(groupid, userid, MsgObj{IsSystem: isSystem, Msg: text, MsgType: msgType, MimeType: mimeType})
+}
+
+// AddObj 添加消息对象
+func (m *MsgLog) AddObj(groupid, userid int64, obj MsgObj) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 	key := m.MakeKey(groupid, userid)
@@ -53,17 +81,32 @@ func (m *MsgLog) AddMsg(groupid, userid int64, text string, isSystem bool, msgTy
 	}
 	var msgsArr []MsgObj
 	_ = json.Unmarshal(msgs, &msgsArr)
-	msgsArr = append(msgsArr, MsgObj{IsSystem: isSystem, Msg: text, MsgType: msgType, MimeType: mimeType})
+	// 序号取当前时间，清空历史后也不会和之前的消息重复
+	obj.Seq = time.Now().UnixNano()
+	if l := len(msgsArr); l > 0 && obj.Seq <= msgsArr[l-1].Seq {
+		obj.Seq = msgsArr[l-1].Seq + 1
+	}
+	msgsArr = append(msgsArr, obj)
+	// 实际发给模型的历史消息由 SelectHistory 按 token 预算选择，这里只限制存储的上限
+	// 开启摘要时超出条数的历史由 compactHistory 压缩后删除，这里放宽到两倍，只在摘要一直失败时兜底
+	conf := Conf().History
+	lenth := conf.MaxEntries
+	if conf.Summary {
+		lenth *= 2
+	}
 	l := len(msgsArr)
-	if l > m.lenth {
-		msgsArr = msgsArr[l-m.lenth:]
+	if l > lenth {
+		msgsArr = msgsArr[l-lenth:]
 	}
 	buf, _ := json.Marshal(msgsArr)
 	_ = m.db.Put([]byte(key), buf, nil)
 }
 
-// MakeKey 生成消息存储的键
+// MakeKey 生成消息存储的键，共享上下文的群里所有成员使用同一个键
 func (m *MsgLog) MakeKey(groupid, userid int64) string {
+	if groupid != 0 && GroupSharedContext(groupid) {
+		return fmt.Sprintf("@chatgpt/group/%d/shared", groupid)
+	}
 	return fmt.Sprintf("@chatgpt/group/%d/user/%d", groupid, userid)
 }
 
@@ -80,3 +123,171 @@ func (m *MsgLog) GetMsgs(groupid, userid int64) []MsgObj {
 	_ = json.Unmarshal(msgs, &msgsArr)
 	return msgsArr
 }
+
+// Clear 清空历史消息
+func (m *MsgLog) Clear(groupid, userid int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.MakeKey(groupid, userid)
+	_ = m.db.Delete([]byte(key), nil)
+	_ = m.db.Delete([]byte(key+"/last"), nil)
+	_ = m.db.Delete([]byte(key+"/summary"), nil)
+}
+
+// DropTurn 删除最后一条消息序号为 seq 的一轮问答，返回被删除的消息
+func (m *MsgLog) DropTurn(groupid, userid int64, seq int64) []MsgObj {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.MakeKey(groupid, userid)
+	rest, dropped := withoutTurn(m.getMsgs(key), seq)
+	if len(dropped) == 0 {
+		return nil
+	}
+	buf, _ := json.Marshal(rest)
+	_ = m.db.Put([]byte(key), buf, nil)
+	return dropped
+}
+
+// withoutTurn 去掉最后一条消息序号为 seq 的一轮问答，返回剩下的消息和被去掉的消息
+func withoutTurn(msgs []MsgObj, seq int64) ([]MsgObj, []MsgObj) {
+	start := 0
+	for _, t := range Turns(msgs) {
+		if t[len(t)-1].Seq == seq {
+			rest := append(append([]MsgObj{}, msgs[:start]...), msgs[start+len(t):]...)
+			return rest, t
+		}
+		start += len(t)
+	}
+	return msgs, nil
+}
+
+// getMsgs 读取历史消息，调用方需要持有锁
+func (m *MsgLog) getMsgs(key string) []MsgObj {
+	msgs, _ := m.db.Get([]byte(key), nil)
+	if msgs == nil {
+		return nil
+	}
+	var msgsArr []MsgObj
+	_ = json.Unmarshal(msgs, &msgsArr)
+	return msgsArr
+}
+
+// ReplaceLastReply 替换最后一条文字回答的内容，用于保存审核后实际发送的回复
+func (m *MsgLog) ReplaceLastReply(groupid, userid int64, text string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.MakeKey(groupid, userid)
+	msgs, _ := m.db.Get([]byte(key), nil)
+	if msgs == nil {
+		return
+	}
+	var msgsArr []MsgObj
+	_ = json.Unmarshal(msgs, &msgsArr)
+	for i := len(msgsArr) - 1; i >= 0; i-- {
+		if msgsArr[i].IsSystem && msgsArr[i].MsgType == MsgTypeText {
+			msgsArr[i].Msg = text
+			buf, _ := json.Marshal(msgsArr)
+			_ = m.db.Put([]byte(key), buf, nil)
+			return
+		}
+	}
+}
+
+// lastInput 最后一次成功回答的提问，Seq 为这轮问答最后一条消息的序号
+type lastInput struct {
+	Message string `json:"message"`
+	Seq     int64  `json:"seq"`
+}
+
+// SetLastInput 保存最后一次提问的原始内容和这轮问答的序号，用于重新生成或撤销，message 为空时清除
+func (m *MsgLog) SetLastInput(groupid, userid int64, message string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.MakeKey(groupid, userid)
+	msgsArr := m.getMsgs(key)
+	if message == "" || len(msgsArr) == 0 {
+		_ = m.db.Delete([]byte(key+"/last"), nil)
+		return
+	}
+	buf, _ := json.Marshal(lastInput{Message: message, Seq: msgsArr[len(msgsArr)-1].Seq})
+	_ = m.db.Put([]byte(key+"/last"), buf, nil)
+}
+
+// GetLastInput 获取最后一次提问的原始内容和这轮问答的序号，之后历史消息有变化时返回空
+func (m *MsgLog) GetLastInput(groupid, userid int64) (string, int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.MakeKey(groupid, userid)
+	var last lastInput
+	buf, err := m.db.Get([]byte(key+"/last"), nil)
+	if err != nil || json.Unmarshal(buf, &last) != nil || last.Seq == 0 {
+		return "", 0
+	}
+	msgsArr := m.getMsgs(key)
+	if len(msgsArr) == 0 || msgsArr[len(msgsArr)-1].Seq != last.Seq {
+		return "", 0
+	}
+	return last.Message, last.Seq
+}
+
+// Turns 将历史消息按轮次切分，每轮由用户消息和随后的回答（包括工具调用）组成
+func Turns(msgs []MsgObj) [][]MsgObj {
+	var turns [][]MsgObj
+	start := 0
+	for i := 1; i < len(msgs); i++ {
+		if !msgs[i].IsSystem && msgs[i-1].IsSystem {
+			turns = append(turns, msgs[start:i])
+			start = i
+		}
+	}
+	if start < len(msgs) {
+		turns = append(turns, msgs[start:])
+	}
+	return turns
+}
+
+// GetSummary 获取早期对话的摘要
+func (m *MsgLog) GetSummary(groupid, userid int64) string {
+	buf, _ := m.db.Get([]byte(m.MakeKey(groupid, userid)+"/summary"), nil)
+	return string(buf)
+}
+
+// SetSummary 保存早期对话的摘要
+func (m *MsgLog) SetSummary(groupid, userid int64, summary string) {
+	_ = m.db.Put([]byte(m.MakeKey(groupid, userid)+"/summary"), []byte(summary), nil)
+}
+
+// TrimFront 删除已经合并进摘要的消息，即开头序号不超过 dropped 最后一条的消息
+// 这些消息可能已经被条数上限删除了一部分，按序号删除不要求开头完全一致
+// 旧数据没有序号时按位置删除开头的 len(dropped) 条，没有删除任何消息时返回 false
+func (m *MsgLog) TrimFront(groupid, userid int64, dropped []MsgObj) bool {
+	if len(dropped) == 0 {
+		return false
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	key := m.MakeKey(groupid, userid)
+	msgs, _ := m.db.Get([]byte(key), nil)
+	if msgs == nil {
+		return false
+	}
+	var msgsArr []MsgObj
+	_ = json.Unmarshal(msgs, &msgsArr)
+	n := trimCount(msgsArr, dropped)
+	if n == 0 {
+		return false
+	}
+	buf, _ := json.Marshal(msgsArr[n:])
+	_ = m.db.Put([]byte(key), buf, nil)
+	return true
+}
+
+// trimCount 计算 msgs 开头属于 dropped 的消息条数
+func trimCount(msgs, dropped []MsgObj) int {
+	last := dropped[len(dropped)-1].Seq
+	n := 0
+	for n < len(msgs) && msgs[n].Seq <= last && (last > 0 || n < len(dropped)) {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,93 @@
+package bot
+
+import "testing"
+
+// seqs 返回消息的序号
+func seqs(msgs []MsgObj) []int64 {
+	var s []int64
+	for _, m := range msgs {
+		s = append(s, m.Seq)
+	}
+	return s
+}
+
+func equalSeqs(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTurns(t *testing.T) {
+	msgs := append(append(turn(10, 1), turn(20, 3)...), MsgObj{Msg: "hi", Seq: 30}, MsgObj{Msg: "again", Seq: 31})
+	turns := Turns(msgs)
+	want := [][]int64{{10, 11}, {20, 21, 22, 23}, {30, 31}}
+	if len(turns) != len(want) {
+		t.Fatalf("Turns = %d turns, want %d", len(turns), len(want))
+	}
+	for i := range want {
+		if got := seqs(turns[i]); !equalSeqs(got, want[i]) {
+			t.Errorf("turn %d = %v, want %v", i, got, want[i])
+		}
+	}
+	if turns := Turns(nil); len(turns) != 0 {
+		t.Errorf("Turns(nil) = %v", turns)
+	}
+}
+
+func TestTrimCount(t *testing.T) {
+	msgs := append(append(turn(10, 1), turn(20, 1)...), turn(30, 1)...)
+	legacy := []MsgObj{{Msg: "a"}, {IsSystem: true, Msg: "b"}, {Msg: "c"}, {IsSystem: true, Msg: "d"}}
+	tests := []struct {
+		name    string
+		msgs    []MsgObj
+		dropped []MsgObj
+		want    int
+	}{
+		{"same prefix", msgs, msgs[:2], 2},
+		{"two turns", msgs, msgs[:4], 4},
+		// 开头已经被条数上限删除了一部分
+		{"front already trimmed", msgs[1:], msgs[:4], 3},
+		{"all trimmed", msgs[4:], msgs[:4], 0},
+		{"cleared", nil, msgs[:2], 0},
+		// 清空后的新消息序号更大，不会被删除
+		{"after clear", turn(40, 1), msgs[:2], 0},
+		{"legacy by position", legacy, legacy[:2], 2},
+		{"legacy longer than history", legacy[:1], legacy[:2], 1},
+	}
+	for _, tt := range tests {
+		if got := trimCount(tt.msgs, tt.dropped); got != tt.want {
+			t.Errorf("%s: trimCount = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestWithoutTurn(t *testing.T) {
+	msgs := append(append(turn(10, 1), turn(20, 3)...), turn(30, 1)...)
+	tests := []struct {
+		seq         int64
+		wantRest    []int64
+		wantDropped []int64
+	}{
+		{31, []int64{10, 11, 20, 21, 22, 23}, []int64{30, 31}},
+		{23, []int64{10, 11, 30, 31}, []int64{20, 21, 22, 23}},
+		{11, []int64{20, 21, 22, 23, 30, 31}, []int64{10, 11}},
+		// 只匹配一轮的最后一条消息
+		{21, seqs(msgs), nil},
+		{99, seqs(msgs), nil},
+	}
+	for _, tt := range tests {
+		rest, dropped := withoutTurn(msgs, tt.seq)
+		if !equalSeqs(seqs(rest), tt.wantRest) || !equalSeqs(seqs(dropped), tt.wantDropped) {
+			t.Errorf("withoutTurn(%d) = %v, %v, want %v, %v", tt.seq, seqs(rest), seqs(dropped), tt.wantRest, tt.wantDropped)
+		}
+	}
+	if len(msgs) != 8 || msgs[7].Seq != 31 {
+		t.Errorf("withoutTurn modified its input: %v", seqs(msgs))
+	}
+}
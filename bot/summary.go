@@ -0,0 +1,113 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// summarizeFunc 使用提供者自身的模型完成一次简单的文本生成，返回生成的文本和用量
+type summarizeFunc func(ctx context.Context, prompt string) (string, Usage, error)
+
+// summarizing 正在生成摘要的对话，避免连续的消息重复压缩同一段历史
+var summarizing sync.Map
+
+// systemPrompt 系统提示词，共享上下文时说明发言人的标注，开启群聊记录时附上最近的记录，有摘要时附在人设提示词之后
+func systemPrompt(groupID, userID int64, persona *Persona) string {
+	prompt := persona.Prompt
+	if groupID != 0 && GroupSharedContext(groupID) {
+		prompt += "\n\n你在一个群聊中和多位群成员对话，每条用户消息的开头标注了发言人的名字。"
+	}
+	if recent := groupLogPrompt(groupID); recent != "" {
+		prompt += "\n\n" + recent
+	}
+	summary := Msglog.GetSummary(groupID, userID)
+	if summary == "" {
+		return prompt
+	}
+	return fmt.Sprintf("%s\n\n以下是之前对话的摘要，请结合它理解上下文：\n%s", prompt, summary)
+}
+
+// compactHistory 在后台将超出预算被丢弃的前 cut 条历史消息合并进摘要，然后从历史中删除它们
+// 条数超过 history.max_entries 时也按整轮压缩，关闭 history.summary 后超出的部分直接丢弃
+// 生成摘要的调用和回复一样记录审计日志并计入 provider 的用量
+func compactHistory(groupID, userID int64, provider string, allMsgs []MsgObj, cut int, summarize summarizeFunc) {
+	conf := Conf().History
+	if !conf.Summary {
+		return
+	}
+	if over := len(allMsgs) - conf.MaxEntries; over > cut {
+		cut = turnBoundary(allMsgs, over)
+	}
+	if cut == 0 {
+		return
+	}
+	dropped := allMsgs[:cut]
+	key := Msglog.MakeKey(groupID, userID)
+	if _, loaded := summarizing.LoadOrStore(key, true); loaded {
+		return
+	}
+	goBackground(func(ctx context.Context) {
+		defer summarizing.Delete(key)
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+		defer cancel()
+		start := time.Now()
+		summary, usage, err := summarize(ctx, summaryPrompt(Msglog.GetSummary(groupID, userID), dropped))
+		recordCall(groupID, userID, provider, AuditKindSummary, usage, start, err)
+		if err != nil {
+			log.Errorf("summarize history %s err:%v", key, err)
+			return
+		}
+		summary = strings.TrimSpace(summary)
+		if summary == "" {
+			return
+		}
+		if !Msglog.TrimFront(groupID, userID, dropped) {
+			log.Warnf("summarize history %s: 历史消息已被清空，放弃本次摘要", key)
+			return
+		}
+		Msglog.SetSummary(groupID, userID, summary)
+		log.Infof("summarize history %s: 压缩 %d 条历史消息", key, len(dropped))
+	})
+}
+
+// turnBoundary 返回不少于 n 条的最小整轮消息条数
+func turnBoundary(msgs []MsgObj, n int) int {
+	cut := 0
+	for _, t := range Turns(msgs) {
+		if cut >= n {
+			break
+		}
+		cut += len(t)
+	}
+	return cut
+}
+
+// summaryPrompt 生成摘要请求的提示词
+func summaryPrompt(old string, msgs []MsgObj) string {
+	var b strings.Builder
+	b.WriteString("请将下面的对话压缩成一段简洁的中文摘要，保留涉及的人物、事实、结论和尚未完成的事项，不超过 300 字，只输出摘要本身。\n")
+	if old != "" {
+		b.WriteString("\n已有的摘要：\n")
+		b.WriteString(old)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n需要合并进摘要的对话：\n")
+	for _, s := range msgs {
+		role := "用户"
+		if s.IsSystem {
+			role = "助手"
+		}
+		switch s.MsgType {
+		case MsgTypeText:
+			fmt.Fprintf(&b, "%s：%s\n", role, s.Msg)
+		case MsgTypeImage:
+			fmt.Fprintf(&b, "%s：[图片]\n", role)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,24 @@
+package bot
+
+import "testing"
+
+func TestTurnBoundary(t *testing.T) {
+	msgs := append(append(turn(10, 1), turn(20, 3)...), turn(30, 1)...)
+	tests := []struct {
+		n    int
+		want int
+	}{
+		{0, 0},
+		{1, 2},
+		{2, 2},
+		{3, 6},
+		{6, 6},
+		{7, 8},
+		{100, 8},
+	}
+	for _, tt := range tests {
+		if got := turnBoundary(msgs, tt.n); got != tt.want {
+			t.Errorf("turnBoundary(%d) = %d, want %d", tt.n, got, tt.want)
+		}
+	}
+}
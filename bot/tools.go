@@ -0,0 +1,326 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// ToolFetchMaxLength fetch_url 返回给模型的最大字数
+const ToolFetchMaxLength = 4000
+
+// Tool 可供模型调用的工具
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON Schema 格式的参数定义
+	Call        func(ctx context.Context, conv *Conversation, args json.RawMessage) (string, error)
+}
+
+// toolCall 历史消息中记录的一次工具调用
+type toolCall struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+}
+
+var (
+	toolsLock sync.RWMutex
+	tools     []*Tool
+)
+
+// RegisterTool 注册工具
+func RegisterTool(t *Tool) {
+	toolsLock.Lock()
+	defer toolsLock.Unlock()
+	tools = append(tools, t)
+}
+
+// GetTool 根据名称获取工具
+func GetTool(name string) *Tool {
+	toolsLock.RLock()
+	defer toolsLock.RUnlock()
+	for _, t := range tools {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// openaiTools 转换成 openai 的工具定义
+func openaiTools() []openai.ChatCompletionToolParam {
+	toolsLock.RLock()
+	defer toolsLock.RUnlock()
+	params := make([]openai.ChatCompletionToolParam, 0, len(tools))
+	for _, t := range tools {
+		params = append(params, openai.ChatCompletionToolParam{
+			Function: openai.FunctionDefinitionParam{
+				Name:        t.Name,
+				Description: openai.String(t.Description),
+				Parameters:  t.Parameters,
+			},
+		})
+	}
+	return params
+}
+
+// callTool 执行工具调用，出错时把错误信息作为结果交给模型处理
+func callTool(ctx context.Context, conv *Conversation, name string, args string) string {
+	t := GetTool(name)
+	if t == nil {
+		return fmt.Sprintf("error: unknown tool %s", name)
+	}
+	if args == "" {
+		args = "{}"
+	}
+	result, err := t.Call(ctx, conv, json.RawMessage(args))
+	if err != nil {
+		log.Warnf("tool %s args=%s err:%v", name, args, err)
+		return fmt.Sprintf("error: %v", err)
+	}
+	log.Debugf("tool %s args=%s result=%s", name, args, result)
+	return result
+}
+
+// shanghai 东八区时区，容器内没有时区数据时使用固定偏移
+func shanghai() *time.Location {
+	loc, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		return time.FixedZone("CST", 8*3600)
+	}
+	return loc
+}
+
+func init() {
+	RegisterTool(&Tool{
+		Name:        "get_current_time",
+		Description: "获取当前的北京时间（Asia/Shanghai）、日期和星期",
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{},
+		},
+		Call: func(_ context.Context, _ *Conversation, _ json.RawMessage) (string, error) {
+			now := time.Now().In(shanghai())
+			weekdays := []string{"日", "一", "二", "三", "四", "五", "六"}
+			return fmt.Sprintf("%s 星期%s", now.Format("2006-01-02 15:04:05 MST"), weekdays[now.Weekday()]), nil
+		},
+	})
+	RegisterTool(&Tool{
+		Name:        "calculator",
+		Description: "计算数学表达式，支持 + - * / % ^ 和括号，例如 (1+2)*3^2",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"expression": map[string]interface{}{
+					"type":        "string",
+					"description": "要计算的表达式",
+				},
+			},
+			"required": []string{"expression"},
+		},
+		Call: func(_ context.Context, _ *Conversation, args json.RawMessage) (string, error) {
+			var req struct {
+				Expression string `json:"expression"`
+			}
+			if err := json.Unmarshal(args, &req); err != nil {
+				return "", err
+			}
+			v, err := Calculate(req.Expression)
+			if err != nil {
+				return "", err
+			}
+			return strconv.FormatFloat(v, 'g', -1, 64), nil
+		},
+	})
+	RegisterTool(&Tool{
+		Name:        "search_history",
+		Description: "在当前对话的历史消息中按关键词搜索，返回包含关键词的消息",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"keyword": map[string]interface{}{
+					"type":        "string",
+					"description": "要搜索的关键词",
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": "最多返回的条数，默认 10",
+				},
+			},
+			"required": []string{"keyword"},
+		},
+		Call: searchHistoryTool,
+	})
+	RegisterTool(&Tool{
+		Name:        "fetch_url",
+		Description: "获取网页内容，返回去掉 HTML 标签后的文本",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"url": map[string]interface{}{
+					"type":        "string",
+					"description": "http 或 https 开头的网址",
+				},
+			},
+			"required": []string{"url"},
+		},
+		Call: fetchURLTool,
+	})
+}
+
+// searchHistoryTool 搜索当前对话的历史消息
+func searchHistoryTool(_ context.Context, conv *Conversation, args json.RawMessage) (string, error) {
+	var req struct {
+		Keyword string `json:"keyword"`
+		Limit   int    `json:"limit"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil {
+		return "", err
+	}
+	if req.Keyword == "" {
+		return "", errors.New("keyword 不能为空")
+	}
+	if req.Limit <= 0 {
+		req.Limit = 10
+	}
+	keyword := strings.ToLower(req.Keyword)
+	var matched []string
+	msgs := Msglog.GetMsgs(conv.GroupID, conv.UserID)
+	for i := len(msgs) - 1; i >= 0 && len(matched) < req.Limit; i-- {
+		s := msgs[i]
+		if s.MsgType != MsgTypeText || !strings.Contains(strings.ToLower(s.Msg), keyword) {
+			continue
+		}
+		role := "user"
+		if s.IsSystem {
+			role = "assistant"
+		}
+		matched = append(matched, fmt.Sprintf("[%s] %s", role, s.Msg))
+	}
+	if len(matched) == 0 {
+		return "没有找到相关的历史消息", nil
+	}
+	return strings.Join(matched, "\n"), nil
+}
+
+var (
+	htmlScriptReg = regexp.MustCompile(`(?is)<(script|style)[^>]*>.*?</(script|style)>`)
+	htmlTagReg    = regexp.MustCompile(`(?s)<[^>]*>`)
+	blankLineReg  = regexp.MustCompile(`\n\s*\n+`)
+)
+
+// fetchURLTool 获取网页内容
+func fetchURLTool(ctx context.Context, _ *Conversation, args json.RawMessage) (string, error) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(args, &req); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("只支持 http 和 https 网址")
+	}
+	b, contentType, err := fetch(ctx, u.String(), 1024*1024)
+	if err != nil {
+		return "", err
+	}
+	text := string(b)
+	if strings.Contains(contentType, "html") {
+		text = htmlScriptReg.ReplaceAllString(text, "")
+		text = htmlTagReg.ReplaceAllString(text, "")
+		text = blankLineReg.ReplaceAllString(text, "\n")
+	}
+	text = strings.TrimSpace(text)
+	if r := []rune(text); len(r) > ToolFetchMaxLength {
+		text = string(r[:ToolFetchMaxLength]) + "..."
+	}
+	return text, nil
+}
+
+// fetchClient 获取网页使用的客户端，每次建立连接（包括重定向后）都会检查解析出的地址
+var fetchClient = &http.Client{
+	Transport: &http.Transport{
+		DialContext:           publicDialContext,
+		TLSHandshakeTimeout:   15 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	},
+	Timeout: 60 * time.Second,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 5 {
+			return errors.New("重定向次数过多")
+		}
+		if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+			return errors.New("只支持 http 和 https 网址")
+		}
+		return nil
+	},
+}
+
+// fetch 获取网址的内容，最多读取 limit 字节，超出的部分直接截断
+func fetch(ctx context.Context, rawURL string, limit int64) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	resp, err := fetchClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("请求失败：%s", resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	return b, resp.Header.Get("Content-Type"), err
+}
+
+// publicDialContext 只连接公网地址，拨号时直接使用检查过的 IP，避免检查后 DNS 解析结果变化
+func publicDialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !publicIP(ip.IP) {
+			return nil, fmt.Errorf("不允许访问内网地址 %s", host)
+		}
+	}
+	d := net.Dialer{Timeout: 15 * time.Second}
+	err = fmt.Errorf("无法解析 %s", host)
+	for _, ip := range ips {
+		var conn net.Conn
+		if conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// publicIP 拒绝访问内网地址，避免模型被诱导访问内部服务
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsMulticast() && !ip.IsUnspecified()
+}
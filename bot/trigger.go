@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// TriggerRule 群消息的触发规则，@ 机器人总是会触发
+type TriggerRule struct {
+	Prefixes    []string `yaml:"prefixes" json:"prefixes"`       // 以这些前缀开头的消息
+	Nicknames   []string `yaml:"nicknames" json:"nicknames"`     // 包含机器人昵称的消息，不区分大小写
+	Patterns    []string `yaml:"patterns" json:"patterns"`       // 匹配正则的消息
+	Probability float64  `yaml:"probability" json:"probability"` // 未触发的消息随机插话的概率，0 到 1
+}
+
+// Validate 校验正则和概率
+func (r TriggerRule) Validate() error {
+	for _, p := range r.Patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			return fmt.Errorf("%q 不是有效的正则: %v", p, err)
+		}
+	}
+	// 取反判断，NaN 也会被拒绝
+	if !(r.Probability >= 0 && r.Probability <= 1) {
+		return fmt.Errorf("probability 必须在 0 到 1 之间")
+	}
+	return nil
+}
+
+// nicknameSeparators 昵称后面的称呼分隔符，去掉昵称时一起去掉
+const nicknameSeparators = " ,，:：、!！~"
+
+// Match 判断消息是否触发，返回去掉触发部分后的消息，不包括随机插话
+func (r TriggerRule) Match(message string) (string, bool) {
+	for _, p := range r.Prefixes {
+		if p != "" && strings.HasPrefix(message, p) {
+			return strings.TrimSpace(strings.TrimPrefix(message, p)), true
+		}
+	}
+	for _, n := range r.Nicknames {
+		if n == "" {
+			continue
+		}
+		if loc := compileTrigger(nicknamePattern(n)).FindStringIndex(message); loc != nil {
+			rest := strings.TrimLeft(message[loc[1]:], nicknameSeparators)
+			return strings.TrimSpace(message[:loc[0]] + rest), true
+		}
+	}
+	for _, p := range r.Patterns {
+		re := compileTrigger(p)
+		if re == nil {
+			continue
+		}
+		if loc := re.FindStringIndex(message); loc != nil {
+			return strings.TrimSpace(message[:loc[0]] + message[loc[1]:]), true
+		}
+	}
+	return message, false
+}
+
+// nicknamePattern 昵称的正则，不区分大小写，以字母或数字开头、结尾的昵称需要是完整的单词，避免 Bot 匹配 robot
+func nicknamePattern(n string) string {
+	p := regexp.QuoteMeta(n)
+	if isWordByte(n[0]) {
+		p = `\b` + p
+	}
+	if isWordByte(n[len(n)-1]) {
+		p += `\b`
+	}
+	return "(?i)" + p
+}
+
+// isWordByte 是否为 ASCII 的字母、数字或下划线，和正则中 \b 的定义一致
+func isWordByte(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// ChimeIn 按概率决定是否插话
+func (r TriggerRule) ChimeIn() bool {
+	return r.Probability > 0 && rand.Float64() < r.Probability
+}
+
+var (
+	triggerRegexpLock sync.Mutex
+	triggerRegexps    = make(map[string]*regexp.Regexp)
+)
+
+// compileTrigger 编译并缓存触发正则，无效的正则返回 nil
+func compileTrigger(p string) *regexp.Regexp {
+	triggerRegexpLock.Lock()
+	defer triggerRegexpLock.Unlock()
+	re, ok := triggerRegexps[p]
+	if !ok {
+		re, _ = regexp.Compile(p)
+		triggerRegexps[p] = re
+	}
+	return re
+}
+
+func groupTriggerKey(groupID int64) string {
+	return fmt.Sprintf("@trigger/group/%d", groupID)
+}
+
+// GetGroupTrigger 获取群的触发规则，没有单独设置时使用配置中的默认规则
+func GetGroupTrigger(groupID int64) (TriggerRule, bool) {
+	buf, err := Msglog.db.Get([]byte(groupTriggerKey(groupID)), nil)
+	if err == nil {
+		var r TriggerRule
+		if json.Unmarshal(buf, &r) == nil {
+			return r, true
+		}
+	}
+	return Conf().Trigger, false
+}
+
+// SetGroupTrigger 设置群的触发规则，rule 为 nil 时恢复默认规则
+func SetGroupTrigger(groupID int64, rule *TriggerRule) error {
+	if rule == nil {
+		return Msglog.db.Delete([]byte(groupTriggerKey(groupID)), nil)
+	}
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	buf, _ := json.Marshal(rule)
+	return Msglog.db.Put([]byte(groupTriggerKey(groupID)), buf, nil)
+}
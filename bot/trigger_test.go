@@ -0,0 +1,55 @@
+package bot
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTriggerRuleMatch(t *testing.T) {
+	rule := TriggerRule{
+		Prefixes:  []string{"#", "/ai"},
+		Nicknames: []string{"小爱", "Bot"},
+		Patterns:  []string{`^请问`, `[`},
+	}
+	tests := []struct {
+		message string
+		want    string
+		ok      bool
+	}{
+		{"#今天天气", "今天天气", true},
+		{"/ai  hello", "hello", true},
+		{"小爱，讲个笑话", "讲个笑话", true},
+		{"讲个笑话吧小爱！", "讲个笑话吧", true},
+		{"hey BOT: what time", "hey what time", true},
+		{"请问现在几点", "现在几点", true},
+		{"随便聊聊", "随便聊聊", false},
+		{"中间的#不算", "中间的#不算", false},
+		{"I am a robot", "I am a robot", false},
+		{"bots are here", "bots are here", false},
+		{"叫Bot来帮忙", "叫来帮忙", true},
+	}
+	for _, tt := range tests {
+		got, ok := rule.Match(tt.message)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("Match(%q) = %q, %v, want %q, %v", tt.message, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestTriggerRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule TriggerRule
+		ok   bool
+	}{
+		{TriggerRule{Patterns: []string{`^请问`}, Probability: 0.5}, true},
+		{TriggerRule{Patterns: []string{`[`}}, false},
+		{TriggerRule{Probability: -0.1}, false},
+		{TriggerRule{Probability: 1.5}, false},
+		{TriggerRule{Probability: math.NaN()}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.Validate(); (err == nil) != tt.ok {
+			t.Errorf("Validate(%+v) error = %v, want ok %v", tt.rule, err, tt.ok)
+		}
+	}
+}
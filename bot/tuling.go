@@ -3,11 +3,11 @@ package bot
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
-	"os"
 	"time"
 
 	"github.com/scjtqs2/bot_adapter/coolq"
@@ -15,13 +15,23 @@ import (
 	"github.com/tidwall/gjson"
 )
 
-// TulingKey 图灵收费机器人 API
-var TulingKey string
 var tulingAPI = "http://openapi.tuling123.com/openapi/api/v2"
 
 func init() {
-	TulingKey = os.Getenv("TULING_KEY")
-	log.Warnf("TulingKey:%s", TulingKey)
+	RegisterProvider(40, tulingProvider{})
+}
+
+// tulingProvider 图灵机器人提供者
+type tulingProvider struct{}
+
+func (tulingProvider) Name() string { return "tuling" }
+
+func (tulingProvider) Capabilities() Capability { return 0 }
+
+func (tulingProvider) Enabled() bool { return Conf().Tuling.Key != "" }
+
+func (tulingProvider) Reply(_ context.Context, conv *Conversation) (string, error) {
+	return TulingText(conv.Message, conv.UserID, conv.GroupID)
 }
 
 var tulingErrcode = []int64{4000, 4001, 4002, 4003, 4004, 4005, 4006, 4007, 4100, 4200, 4300, 4400, 4500, 4600, 4602, 5000, 6000, 77002, 8008}
@@ -61,7 +71,7 @@ func TulingText(message string, userID int64, groupID int64) (string, error) {
 	}
 	postData := MSG{
 		"userInfo": MSG{
-			"apiKey":  TulingKey,
+			"apiKey":  Conf().Tuling.Key,
 			"userId":  userID,
 			"groupId": groupID,
 		},
@@ -91,7 +101,7 @@ func TulingText(message string, userID int64, groupID int64) (string, error) {
 func TulingImage(url string, userID int64, groupID int64) (string, error) {
 	postData := MSG{
 		"userInfo": MSG{
-			"apiKey":  TulingKey,
+			"apiKey":  Conf().Tuling.Key,
 			"userId":  userID,
 			"groupId": groupID,
 		},
@@ -0,0 +1,76 @@
+package bot
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseModelBudgets 解析 模型:预算 的逗号分隔列表，格式错误的项会被忽略
+// 例如 HISTORY_MODEL_BUDGETS=gpt-4o-mini:60000,qwen2.5:4000
+func ParseModelBudgets(s string) map[string]int {
+	budgets := make(map[string]int)
+	for _, item := range strings.Split(s, ",") {
+		idx := strings.LastIndex(item, ":")
+		if idx <= 0 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(item[idx+1:]))
+		if err != nil || n <= 0 {
+			continue
+		}
+		budgets[strings.TrimSpace(item[:idx])] = n
+	}
+	return budgets
+}
+
+// TokenBudget 返回模型的历史消息 token 预算
+func TokenBudget(model string) int {
+	conf := Conf()
+	if n, ok := conf.History.ModelBudgets[model]; ok {
+		return n
+	}
+	return conf.History.TokenBudget
+}
+
+// EstimateTokens 估算一条历史消息的 token 数
+// 中日韩文字大约每字 1 个 token，其他文字大约每 4 个字节 1 个 token，图片按固定值计算
+func EstimateTokens(s MsgObj) int {
+	if s.MsgType == MsgTypeImage {
+		return Conf().History.ImageTokens
+	}
+	cjk, other := 0, 0
+	for i := 0; i < len(s.Msg); {
+		r, size := utf8.DecodeRuneInString(s.Msg[i:])
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other += size
+		}
+		i += size
+	}
+	// 每条消息额外计算角色等格式开销
+	return cjk + (other+3)/4 + 4
+}
+
+// SelectHistory 从最新的一轮开始往前按整轮选择历史消息，直到用完预算
+// 返回选中的消息以及前面被丢弃的消息条数
+func SelectHistory(msgs []MsgObj, budget int) ([]MsgObj, int) {
+	turns := Turns(msgs)
+	used := 0
+	kept := 0
+	for i := len(turns) - 1; i >= 0; i-- {
+		cost := 0
+		for _, s := range turns[i] {
+			cost += EstimateTokens(s)
+		}
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		kept += len(turns[i])
+	}
+	cut := len(msgs) - kept
+	return msgs[cut:], cut
+}
@@ -0,0 +1,71 @@
+package bot
+
+import "testing"
+
+// turn 构造一轮问答，每条消息估算为 5 个 token
+func turn(seq int64, replies int) []MsgObj {
+	msgs := []MsgObj{{Msg: "hi", Seq: seq}}
+	for i := 1; i <= replies; i++ {
+		msgs = append(msgs, MsgObj{IsSystem: true, Msg: "ok", Seq: seq + int64(i)})
+	}
+	return msgs
+}
+
+func TestEstimateTokens(t *testing.T) {
+	conf := DefaultConfig()
+	conf.History.ImageTokens = 1000
+	SetConfig(conf)
+	tests := []struct {
+		msg  MsgObj
+		want int
+	}{
+		{MsgObj{}, 4},
+		{MsgObj{Msg: "hi"}, 5},
+		{MsgObj{Msg: "abcdefgh"}, 6},
+		{MsgObj{Msg: "你好"}, 6},
+		{MsgObj{Msg: "你好 abcd"}, 8},
+		{MsgObj{Msg: "data", MsgType: MsgTypeImage}, 1000},
+	}
+	for _, tt := range tests {
+		if got := EstimateTokens(tt.msg); got != tt.want {
+			t.Errorf("EstimateTokens(%+v) = %d, want %d", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestSelectHistory(t *testing.T) {
+	SetConfig(DefaultConfig())
+	var msgs []MsgObj
+	msgs = append(msgs, turn(10, 1)...) // 10 token
+	msgs = append(msgs, turn(20, 3)...) // 20 token，包括工具调用
+	msgs = append(msgs, turn(30, 1)...) // 10 token
+	tests := []struct {
+		budget  int
+		wantCut int
+	}{
+		{9, 8},
+		{10, 6},
+		{29, 6},
+		{30, 2},
+		{39, 2},
+		{40, 0},
+		{1000, 0},
+	}
+	for _, tt := range tests {
+		got, cut := SelectHistory(msgs, tt.budget)
+		if cut != tt.wantCut || len(got) != len(msgs)-tt.wantCut {
+			t.Errorf("SelectHistory(budget=%d) = %d msgs, cut %d, want cut %d", tt.budget, len(got), cut, tt.wantCut)
+			continue
+		}
+		if len(got) > 0 && got[0].IsSystem {
+			t.Errorf("SelectHistory(budget=%d) starts with a reply", tt.budget)
+		}
+	}
+	// 预算不够最新一轮时不发送任何历史，也不会只发送一轮中的部分消息
+	if got, cut := SelectHistory(msgs, 5); len(got) != 0 || cut != len(msgs) {
+		t.Errorf("SelectHistory(budget=5) = %d msgs, cut %d, want none", len(got), cut)
+	}
+	if got, cut := SelectHistory(nil, 100); len(got) != 0 || cut != 0 {
+		t.Errorf("SelectHistory(nil) = %d msgs, cut %d", len(got), cut)
+	}
+}
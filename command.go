@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/scjtqs2/bot_adapter/coolq"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// commandContext 命令执行上下文
+type commandContext struct {
+	ctx  context.Context
+	conv *bot.Conversation
+	role bot.Role // 发送者的权限等级
+	args []string // 命令参数，不包含命令名
+	text string   // 命令名之后的原始文本，保留换行
+}
+
+// reply 回复命令执行结果
+func (c *commandContext) reply(text string) {
+	sendText(c.ctx, c.conv, text)
+}
+
+// replyf 格式化后回复命令执行结果
+func (c *commandContext) replyf(format string, a ...interface{}) {
+	c.reply(fmt.Sprintf(format, a...))
+}
+
+// isGroupManager 群聊中发送者是否为群主、管理员或超级管理员，私聊中总是为 true
+func (c *commandContext) isGroupManager() bool {
+	return c.role >= bot.RoleGroupAdmin
+}
+
+// command 以 # 开头的聊天命令
+type command struct {
+	name   string
+	usage  string
+	perm   bot.Role // 使用命令需要的最低权限
+	handle func(c *commandContext)
+}
+
+var commands = make(map[string]*command)
+
+// registerCommand 注册命令
+func registerCommand(cmd *command) {
+	commands[cmd.name] = cmd
+}
+
+// parseCommand 解析命令，群聊中允许先 @ 机器人
+func parseCommand(message string, selfID int64) (*command, []string, string) {
+	message = strings.ReplaceAll(message, coolq.EnAtCode(fmt.Sprintf("%d", selfID)), "")
+	message = strings.TrimSpace(message)
+	if !strings.HasPrefix(message, "#") {
+		return nil, nil, ""
+	}
+	fields := strings.Fields(strings.TrimPrefix(message, "#"))
+	if len(fields) == 0 {
+		return nil, nil, ""
+	}
+	cmd, ok := commands[strings.ToLower(fields[0])]
+	if !ok {
+		return nil, nil, ""
+	}
+	text := strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(message, "#"), fields[0]))
+	return cmd, fields[1:], text
+}
+
+// handleCommand 处理聊天命令，返回 true 表示消息已被命令处理
+// senderRole 为群聊推送中的 sender.role，私聊为空
+func handleCommand(ctx context.Context, conv *bot.Conversation, senderRole string) bool {
+	cmd, args, text := parseCommand(conv.Message, conv.SelfID)
+	if cmd == nil {
+		return false
+	}
+	c := &commandContext{ctx: ctx, conv: conv, role: bot.UserRole(conv, senderRole), args: args, text: text}
+	// 停用机器人的群里只响应管理员的命令，方便重新启用
+	if conv.IsGroup && !bot.GroupEnabled(conv.GroupID) && c.role < bot.RoleGroupAdmin {
+		return false
+	}
+	if !c.allow(cmd.name, cmd.perm) {
+		return true
+	}
+	cmd.handle(c)
+	return true
+}
+
+// allow 检查发送者是否有权限，没有权限时回复提示
+func (c *commandContext) allow(name string, perm bot.Role) bool {
+	if c.role >= perm {
+		return true
+	}
+	if perm == bot.RoleGroupAdmin {
+		c.replyf("只有群主或管理员可以使用 #%s", name)
+	} else {
+		c.replyf("只有%s可以使用 #%s", perm, name)
+	}
+	return false
+}
+
+func init() {
+	registerCommand(&command{
+		name:  "help",
+		usage: "#help 查看可用命令",
+		handle: func(c *commandContext) {
+			names := make([]string, 0, len(commands))
+			for name := range commands {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			var b strings.Builder
+			b.WriteString("可用命令：")
+			for _, name := range names {
+				b.WriteString("\n")
+				b.WriteString(commands[name].usage)
+			}
+			c.reply(b.String())
+		},
+	})
+}
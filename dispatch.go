@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/scjtqs2/bot_adapter/event"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// busyReply 队列已满时的回复
+const busyReply = "我现在有点忙，请稍后再试"
+
+// dispatcher 处理推送的工作池，同一个对话的推送按顺序处理，不同对话之间并行
+type dispatcher struct {
+	lock    sync.Mutex
+	queues  map[string][]string // 每个对话等待处理的推送，第一条为正在处理的推送
+	pending int                 // 所有对话中等待和正在处理的推送数
+	sem     chan struct{}       // 限制同时处理的推送数
+	idle    chan struct{}       // drain 时创建，推送全部处理完成后关闭
+	process func(data string)   // 处理一条推送
+}
+
+// pushDispatcher 在 setup 中加载配置后创建
+var pushDispatcher *dispatcher
+
+func newDispatcher(workers int) *dispatcher {
+	return &dispatcher{
+		queues: make(map[string][]string),
+		sem:    make(chan struct{}, workers),
+		process: func(data string) {
+			parseMsg(appCtx, data)
+		},
+	}
+}
+
+// conversationKey 推送所属的对话，群聊按群和用户区分，共享上下文的群整个群按顺序处理，非消息事件返回空
+func conversationKey(msg gjson.Result) string {
+	if msg.Get("post_type").String() != "message" {
+		return ""
+	}
+	switch msg.Get("message_type").String() {
+	case event.MessageTypeGroup:
+		if groupID := msg.Get("group_id").Int(); bot.GroupSharedContext(groupID) {
+			return fmt.Sprintf("group/%d", groupID)
+		}
+		return fmt.Sprintf("group/%d/user/%d", msg.Get("group_id").Int(), msg.Get("user_id").Int())
+	case event.MessageTypePrivate:
+		return fmt.Sprintf("user/%d", msg.Get("user_id").Int())
+	}
+	return ""
+}
+
+// submit 将推送放入对话的队列，队列已满时丢弃并回复繁忙
+func (d *dispatcher) submit(data string) {
+	conf := bot.Conf().Worker
+	msg := gjson.Parse(data)
+	key := conversationKey(msg)
+	if key == "" {
+		// 非消息事件没有顺序要求，也不需要回复
+		key = "event"
+	}
+	d.lock.Lock()
+	queue := d.queues[key]
+	if d.pending >= conf.MaxPending || len(queue) >= conf.QueueSize {
+		d.lock.Unlock()
+		log.Warnf("推送队列已满，丢弃 %s 的推送 pending=%d queue=%d", key, d.pending, len(queue))
+		busy(msg)
+		return
+	}
+	d.queues[key] = append(queue, data)
+	d.pending++
+	d.lock.Unlock()
+	if len(queue) == 0 {
+		// 对话没有正在处理的推送，启动新的处理协程
+		go d.run(key)
+	}
+}
+
+// run 按顺序处理一个对话的推送，直到队列为空
+func (d *dispatcher) run(key string) {
+	for {
+		d.lock.Lock()
+		data := d.queues[key][0]
+		d.lock.Unlock()
+
+		d.sem <- struct{}{}
+		d.handle(data)
+		<-d.sem
+
+		d.lock.Lock()
+		d.pending--
+		if d.pending == 0 && d.idle != nil {
+			close(d.idle)
+			d.idle = nil
+		}
+		queue := d.queues[key][1:]
+		if len(queue) == 0 {
+			delete(d.queues, key)
+			d.lock.Unlock()
+			return
+		}
+		d.queues[key] = queue
+		d.lock.Unlock()
+	}
+}
+
+// depth 等待和正在处理的推送数
+func (d *dispatcher) depth() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.pending
+}
+
+// drain 等待所有推送处理完成，超时返回 ctx 的错误
+func (d *dispatcher) drain(ctx context.Context) error {
+	d.lock.Lock()
+	if d.pending == 0 {
+		d.lock.Unlock()
+		return nil
+	}
+	if d.idle == nil {
+		d.idle = make(chan struct{})
+	}
+	idle := d.idle
+	pending := d.pending
+	d.lock.Unlock()
+	log.Infof("等待 %d 条推送处理完成", pending)
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handle 处理一条推送，避免单条推送的 panic 影响整个对话的队列
+func (d *dispatcher) handle(data string) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Errorf("处理推送 panic:%v data:%s", err, data)
+		}
+	}()
+	d.process(data)
+}
+
+// busy 队列已满时回复私聊或触发了机器人的群消息，未触发的群消息和重复的推送直接丢弃
+// 繁忙提示和限流提示共用频率限制，大量推送涌入时每个群或私聊在间隔内只回复一次
+func busy(msg gjson.Result) {
+	if msg.Get("post_type").String() != "message" || bot.Recorded(eventID(msg)) {
+		return
+	}
+	conv := &bot.Conversation{
+		Message:   msg.Get("raw_message").String(),
+		UserID:    msg.Get("user_id").Int(),
+		SelfID:    msg.Get("self_id").Int(),
+		MessageID: msg.Get("message_id").Int(),
+		Client:    botAdapterClient,
+	}
+	if msg.Get("message_type").String() == event.MessageTypeGroup {
+		conv.GroupID = msg.Get("group_id").Int()
+		conv.IsGroup = true
+	}
+	if conv.IsGroup {
+		if !bot.GroupEnabled(conv.GroupID) {
+			return
+		}
+		if cmd, _, _ := parseCommand(conv.Message, conv.SelfID); cmd == nil {
+			if _, ok := groupTrigger(conv.Message, conv.SelfID, conv.GroupID); !ok {
+				return
+			}
+		}
+	}
+	if !bot.ThrottleNotice(conv.GroupID, conv.UserID) {
+		return
+	}
+	// 检查好友列表和发送消息需要请求 bot_adapter，放到协程中，协程数受上面的频率限制
+	go func() {
+		if accessAllowed(appCtx, conv.GroupID, conv.UserID) {
+			sendText(appCtx, conv, busyReply)
+		}
+	}()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// privateMsg 构造一条私聊推送
+func privateMsg(userID int64, n int) string {
+	return fmt.Sprintf(`{"post_type":"message","message_type":"private","user_id":%d,"raw_message":"%d"}`, userID, n)
+}
+
+func TestDispatcherOrder(t *testing.T) {
+	conf := bot.DefaultConfig()
+	conf.Worker.QueueSize = 100
+	conf.Worker.MaxPending = 1000
+	bot.SetConfig(conf)
+
+	const users, msgs = 5, 50
+	d := newDispatcher(3)
+	var lock sync.Mutex
+	got := make(map[int64][]int)
+	running := make(map[int64]bool)
+	d.process = func(data string) {
+		msg := gjson.Parse(data)
+		user := msg.Get("user_id").Int()
+		lock.Lock()
+		if running[user] {
+			t.Errorf("user %d: pushes processed concurrently", user)
+		}
+		running[user] = true
+		lock.Unlock()
+		time.Sleep(time.Millisecond)
+		lock.Lock()
+		running[user] = false
+		got[user] = append(got[user], int(msg.Get("raw_message").Int()))
+		lock.Unlock()
+	}
+	for i := 0; i < msgs; i++ {
+		for u := int64(1); u <= users; u++ {
+			d.submit(privateMsg(u, i))
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.drain(ctx); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if n := d.depth(); n != 0 {
+		t.Errorf("depth after drain = %d, want 0", n)
+	}
+	for u := int64(1); u <= users; u++ {
+		if len(got[u]) != msgs {
+			t.Errorf("user %d: processed %d pushes, want %d", u, len(got[u]), msgs)
+			continue
+		}
+		for i, n := range got[u] {
+			if n != i {
+				t.Errorf("user %d: push %d processed at position %d", u, n, i)
+				break
+			}
+		}
+	}
+}
+
+func TestDispatcherQueueFull(t *testing.T) {
+	conf := bot.DefaultConfig()
+	conf.Worker.QueueSize = 2
+	conf.Worker.MaxPending = 1000
+	bot.SetConfig(conf)
+
+	d := newDispatcher(1)
+	release := make(chan struct{})
+	var lock sync.Mutex
+	var processed []string
+	d.process = func(data string) {
+		<-release
+		lock.Lock()
+		processed = append(processed, data)
+		lock.Unlock()
+	}
+	// 非消息事件队列满时直接丢弃，不会回复繁忙
+	for i := 0; i < 5; i++ {
+		d.submit(fmt.Sprintf(`{"post_type":"notice","n":%d}`, i))
+	}
+	if n := d.depth(); n != 2 {
+		t.Errorf("depth = %d, want 2", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("drain with blocked pushes = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.drain(ctx); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if len(processed) != 2 || gjson.Get(processed[0], "n").Int() != 0 || gjson.Get(processed[1], "n").Int() != 1 {
+		t.Errorf("processed = %v, want the first two pushes", processed)
+	}
+	// 没有推送时 drain 立即返回
+	if err := d.drain(context.Background()); err != nil {
+		t.Errorf("drain when idle: %v", err)
+	}
+}
+
+func TestDispatcherPanic(t *testing.T) {
+	bot.SetConfig(bot.DefaultConfig())
+
+	d := newDispatcher(1)
+	var lock sync.Mutex
+	var processed []int
+	d.process = func(data string) {
+		n := int(gjson.Get(data, "raw_message").Int())
+		if n == 0 {
+			panic("boom")
+		}
+		lock.Lock()
+		processed = append(processed, n)
+		lock.Unlock()
+	}
+	d.submit(privateMsg(1, 0))
+	d.submit(privateMsg(1, 1))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := d.drain(ctx); err != nil {
+		t.Fatalf("drain: %v", err)
+	}
+	if len(processed) != 1 || processed[0] != 1 {
+		t.Errorf("processed = %v, want [1]", processed)
+	}
+}
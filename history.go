@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+func init() {
+	registerCommand(&command{
+		name:   "reset",
+		usage:  "#reset 清空当前对话的上下文",
+		handle: resetCommand,
+	})
+	registerCommand(&command{
+		name:   "history",
+		usage:  "#history [N] 查看最近 N 轮对话，默认 5",
+		handle: historyCommand,
+	})
+	registerCommand(&command{
+		name:   "retry",
+		usage:  "#retry 用上一次的提问重新生成回答",
+		handle: retryCommand,
+	})
+	registerCommand(&command{
+		name:   "undo",
+		usage:  "#undo 撤销最后一轮问答",
+		handle: undoCommand,
+	})
+}
+
+// sharedHistoryAllowed 共享上下文的群里只有管理员可以清空、撤销或重新生成整个群的历史
+func (c *commandContext) sharedHistoryAllowed(name string) bool {
+	if !c.conv.IsGroup || !bot.GroupSharedContext(c.conv.GroupID) {
+		return true
+	}
+	return c.allow(name, bot.RoleGroupAdmin)
+}
+
+func resetCommand(c *commandContext) {
+	if !c.sharedHistoryAllowed("reset") {
+		return
+	}
+	bot.Msglog.Clear(c.conv.GroupID, c.conv.UserID)
+	c.reply("上下文已清空")
+}
+
+func historyCommand(c *commandContext) {
+	n := 5
+	if len(c.args) > 0 {
+		v, err := strconv.Atoi(c.args[0])
+		if err != nil || v <= 0 {
+			c.reply("N 需要是正整数")
+			return
+		}
+		n = v
+	}
+	turns := bot.Turns(bot.Msglog.GetMsgs(c.conv.GroupID, c.conv.UserID))
+	if len(turns) == 0 {
+		c.reply("暂无历史消息")
+		return
+	}
+	if len(turns) > n {
+		turns = turns[len(turns)-n:]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "最近 %d 轮对话：", len(turns))
+	for _, turn := range turns {
+		b.WriteString("\n")
+		for _, s := range turn {
+			if line := formatHistory(s); line != "" {
+				b.WriteString("\n")
+				b.WriteString(line)
+			}
+		}
+	}
+	c.reply(b.String())
+}
+
+// formatHistory 格式化一条历史消息，工具调用的结果不展示
+func formatHistory(s bot.MsgObj) string {
+	role := "我"
+	if s.IsSystem {
+		role = "机器人"
+	}
+	switch s.MsgType {
+	case bot.MsgTypeText:
+		return fmt.Sprintf("%s：%s", role, s.Msg)
+	case bot.MsgTypeImage:
+		return fmt.Sprintf("%s：[图片]", role)
+	case bot.MsgTypeToolCall:
+		return fmt.Sprintf("%s：[调用工具]", role)
+	}
+	return ""
+}
+
+func retryCommand(c *commandContext) {
+	if !c.sharedHistoryAllowed("retry") {
+		return
+	}
+	conv := c.conv
+	last, seq := bot.Msglog.GetLastInput(conv.GroupID, conv.UserID)
+	if last == "" {
+		c.reply("没有可以重新生成的提问")
+		return
+	}
+	exempt := rateLimitExempt(conv, c.role)
+	if !exempt && !allowRequest(conv, "") {
+		throttled(c.ctx, conv)
+		return
+	}
+	// 原来的一轮不作为上下文，重新回答成功后才删除
+	retry := *conv
+	retry.Message = last
+	retry.ReplaceTurn = seq
+	if !reply(c.ctx, &retry, exempt) {
+		c.reply("重新生成失败，请稍后再试")
+	}
+}
+
+func undoCommand(c *commandContext) {
+	if !c.sharedHistoryAllowed("undo") {
+		return
+	}
+	// 只撤销记录过的最后一轮，回退到不保存历史的提供者回答时不会误删更早的一轮
+	_, seq := bot.Msglog.GetLastInput(c.conv.GroupID, c.conv.UserID)
+	if seq == 0 || len(bot.Msglog.DropTurn(c.conv.GroupID, c.conv.UserID, seq)) == 0 {
+		c.reply("暂无可以撤销的问答")
+		return
+	}
+	// 撤销后上一次的提问已不在上下文中，不能再 #retry
+	bot.Msglog.SetLastInput(c.conv.GroupID, c.conv.UserID, "")
+	c.reply("已撤销最后一轮问答")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/scjtqs2/bot_adapter/sha256"
+	log "github.com/sirupsen/logrus"
+	"github.com/tidwall/gjson"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// 推送被拒绝的原因
+const (
+	rejectBadBody = "bad_body" // 请求体不是有效的推送
+	rejectNoSign  = "no_sign"  // 缺少签名相关的 header
+	rejectBadSign = "bad_sign" // 签名不匹配
+	rejectExpired = "expired"  // 时间戳不在允许的范围内
+	rejectReplay  = "replay"   // 重复的推送
+	rejectDecrypt = "decrypt"  // 所有密码都无法解密
+)
+
+// reject 拒绝推送并计数
+func reject(ctx iris.Context, status int, reason string, format string, a ...interface{}) {
+	pushRejected.Inc(reason)
+	log.Warnf("拒绝推送 %s from %s: "+format, append([]interface{}{reason, ctx.RemoteAddr()}, a...)...)
+	_ = ctx.StopWithJSON(status, bot.MSG{
+		"code": status,
+		"msg":  reason,
+	})
+}
+
+// replayCache 记录时间窗口内见过的推送签名
+// adapter 的 nonce 以秒为种子生成，同一秒内的不同推送 nonce 相同，所以用覆盖了请求体的签名判断是否重复
+type replayCache struct {
+	lock sync.Mutex
+	seen map[string]time.Time
+}
+
+var pushReplay = &replayCache{seen: make(map[string]time.Time)}
+
+// check 记录签名，签名在有效期内已经出现过时返回 false
+func (r *replayCache) check(sign string, ttl time.Duration) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := time.Now()
+	if t, ok := r.seen[sign]; ok && now.Sub(t) < ttl {
+		return false
+	}
+	// 时间窗口之外的推送会被时间戳校验拒绝，不需要再保存
+	if len(r.seen) > 1024 {
+		for k, t := range r.seen {
+			if now.Sub(t) >= ttl {
+				delete(r.seen, k)
+			}
+		}
+	}
+	r.seen[sign] = now
+	return true
+}
+
+// msginput 接收 bot_adapter 的推送，校验签名、时间戳并解密
+func msginput(ctx iris.Context) {
+	conf := bot.Conf().App
+	raw, err := ctx.GetBody()
+	if err != nil {
+		reject(ctx, http.StatusBadRequest, rejectBadBody, "read body err:%v", err)
+		return
+	}
+	enc := gjson.GetBytes(raw, "encrypt").String()
+	if enc == "" {
+		reject(ctx, http.StatusBadRequest, rejectBadBody, "empty encrypt")
+		return
+	}
+	keys := conf.EncryptKeys()
+	if conf.VerifySignature {
+		key, ok := verifyPush(ctx, raw, keys, time.Duration(conf.TimestampWindow)*time.Second)
+		if !ok {
+			return
+		}
+		// 签名已经确定了使用的密码
+		keys = []string{key}
+	}
+	msg, ok := decryptPush(enc, keys)
+	if !ok {
+		reject(ctx, http.StatusUnauthorized, rejectDecrypt, "enc:%s", enc)
+		return
+	}
+	eventsTotal.Inc(gjson.Get(msg, "post_type").String())
+	pushDispatcher.submit(msg)
+	_ = ctx.JSON(bot.MSG{
+		"code": 200,
+		"msg":  "received",
+	})
+}
+
+// verifyPush 校验推送的时间戳和签名，返回签名匹配的密码
+func verifyPush(ctx iris.Context, raw []byte, keys []string, window time.Duration) (string, bool) {
+	key, reason, detail := checkPush(ctx.GetHeader("X-Lark-Request-Timestamp"), ctx.GetHeader("X-Lark-Request-Nonce"),
+		ctx.GetHeader("X-Lark-Signature"), raw, keys, window, time.Now())
+	switch reason {
+	case "":
+		return key, true
+	case rejectReplay:
+		reject(ctx, http.StatusConflict, reason, "%s", detail)
+	default:
+		reject(ctx, http.StatusUnauthorized, reason, "%s", detail)
+	}
+	return "", false
+}
+
+// checkPush 校验时间戳和签名，通过时返回签名匹配的密码，否则返回拒绝的原因和说明
+func checkPush(timestamp, nonce, sign string, raw []byte, keys []string, window time.Duration, now time.Time) (key, reason, detail string) {
+	if timestamp == "" || nonce == "" || sign == "" {
+		return "", rejectNoSign, "missing signature headers"
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", rejectExpired, fmt.Sprintf("invalid timestamp %q", timestamp)
+	}
+	if d := now.Sub(time.Unix(ts, 0)); d > window || d < -window {
+		return "", rejectExpired, fmt.Sprintf("timestamp %d out of window", ts)
+	}
+	for _, key := range keys {
+		expect := sha256.CalculateSignature(timestamp, nonce, key, string(raw))
+		if subtle.ConstantTimeCompare([]byte(expect), []byte(sign)) != 1 {
+			continue
+		}
+		// 窗口两侧都允许偏移，签名需要保留两倍窗口的时间
+		if !pushReplay.check(sign, 2*window) {
+			return "", rejectReplay, "nonce:" + nonce
+		}
+		return key, "", ""
+	}
+	return "", rejectBadSign, "signature mismatch"
+}
+
+// decryptPush 依次尝试每个密码解密，解密结果是有效的 json 才算成功
+func decryptPush(enc string, keys []string) (string, bool) {
+	for _, key := range keys {
+		if msg, ok := decryptWith(enc, key); ok {
+			return msg, true
+		}
+	}
+	return "", false
+}
+
+// decryptWith 使用错误的密码解密时去除填充可能越界，这里当作解密失败
+func decryptWith(enc, key string) (msg string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			msg, ok = "", false
+		}
+	}()
+	msg, err := sha256.Decrypt(enc, key)
+	return msg, err == nil && gjson.Valid(msg)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/scjtqs2/bot_adapter/sha256"
+)
+
+func TestCheckPush(t *testing.T) {
+	pushReplay = &replayCache{seen: make(map[string]time.Time)}
+	const window = 300 * time.Second
+	now := time.Unix(1700000000, 0)
+	keys := []string{"primary-key", "secondary-key"}
+	raw := []byte(`{"encrypt":"payload"}`)
+	sign := func(ts int64, nonce, key string) (string, string) {
+		timestamp := strconv.FormatInt(ts, 10)
+		return timestamp, sha256.CalculateSignature(timestamp, nonce, key, string(raw))
+	}
+
+	tests := []struct {
+		name    string
+		ts      int64
+		nonce   string
+		key     string // 用来签名的密码
+		nosign  bool
+		want    string // 期望匹配的密码
+		wantErr string // 期望拒绝的原因
+	}{
+		{name: "now", ts: now.Unix(), nonce: "n1", key: "primary-key", want: "primary-key"},
+		{name: "window past edge", ts: now.Add(-window).Unix(), nonce: "n2", key: "primary-key", want: "primary-key"},
+		{name: "window future edge", ts: now.Add(window).Unix(), nonce: "n3", key: "primary-key", want: "primary-key"},
+		{name: "expired", ts: now.Add(-window - time.Second).Unix(), nonce: "n4", key: "primary-key", wantErr: rejectExpired},
+		{name: "too early", ts: now.Add(window + time.Second).Unix(), nonce: "n5", key: "primary-key", wantErr: rejectExpired},
+		{name: "rotated key", ts: now.Unix(), nonce: "n6", key: "secondary-key", want: "secondary-key"},
+		{name: "bad sign", ts: now.Unix(), nonce: "n7", key: "unknown-key", wantErr: rejectBadSign},
+		{name: "missing sign", ts: now.Unix(), nonce: "n8", nosign: true, wantErr: rejectNoSign},
+		{name: "missing nonce", ts: now.Unix(), key: "primary-key", wantErr: rejectNoSign},
+	}
+	for _, tt := range tests {
+		timestamp, s := sign(tt.ts, tt.nonce, tt.key)
+		if tt.nosign {
+			s = ""
+		}
+		key, reason, _ := checkPush(timestamp, tt.nonce, s, raw, keys, window, now)
+		if reason != tt.wantErr || key != tt.want {
+			t.Errorf("%s: checkPush = (%q, %q), want (%q, %q)", tt.name, key, reason, tt.want, tt.wantErr)
+		}
+	}
+
+	if _, reason, _ := checkPush("abc", "n9", "sign", raw, keys, window, now); reason != rejectExpired {
+		t.Errorf("invalid timestamp: reason = %q, want %q", reason, rejectExpired)
+	}
+}
+
+func TestCheckPushReplay(t *testing.T) {
+	pushReplay = &replayCache{seen: make(map[string]time.Time)}
+	const window = 300 * time.Second
+	now := time.Now()
+	raw := []byte(`{"encrypt":"replay"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	s := sha256.CalculateSignature(timestamp, "replay-nonce", "key", string(raw))
+
+	if _, reason, _ := checkPush(timestamp, "replay-nonce", s, raw, []string{"key"}, window, now); reason != "" {
+		t.Fatalf("first push rejected: %q", reason)
+	}
+	if _, reason, _ := checkPush(timestamp, "replay-nonce", s, raw, []string{"key"}, window, now); reason != rejectReplay {
+		t.Errorf("second push: reason = %q, want %q", reason, rejectReplay)
+	}
+	// 签名错误的推送不记录，不会让之后正确的推送被当作重复
+	other := []byte(`{"encrypt":"other"}`)
+	bad := sha256.CalculateSignature(timestamp, "replay-nonce", "wrong", string(other))
+	if _, reason, _ := checkPush(timestamp, "replay-nonce", bad, other, []string{"key"}, window, now); reason != rejectBadSign {
+		t.Errorf("bad sign: reason = %q, want %q", reason, rejectBadSign)
+	}
+	good := sha256.CalculateSignature(timestamp, "replay-nonce", "key", string(other))
+	if _, reason, _ := checkPush(timestamp, "replay-nonce", good, other, []string{"key"}, window, now); reason != "" {
+		t.Errorf("push after bad sign rejected: %q", reason)
+	}
+}
+
+func TestReplayCache(t *testing.T) {
+	r := &replayCache{seen: make(map[string]time.Time)}
+	if !r.check("a", time.Minute) {
+		t.Fatal("first check = false")
+	}
+	if r.check("a", time.Minute) {
+		t.Error("repeated check = true")
+	}
+	if !r.check("b", time.Minute) {
+		t.Error("other sign = false")
+	}
+	// 过期的签名可以再次出现
+	r.seen["a"] = time.Now().Add(-time.Minute)
+	if !r.check("a", time.Minute) {
+		t.Error("expired sign = false")
+	}
+}
+
+func TestDecryptPush(t *testing.T) {
+	keys := []string{"primary-key", "secondary-key"}
+	encrypt := func(data, key string) string {
+		enc, err := sha256.Encrypt([]byte(data), key)
+		if err != nil {
+			t.Fatalf("Encrypt: %v", err)
+		}
+		return enc
+	}
+	msg := `{"post_type":"message"}`
+
+	tests := []struct {
+		name string
+		enc  string
+		ok   bool
+	}{
+		{"primary key", encrypt(msg, "primary-key"), true},
+		{"rotated key", encrypt(msg, "secondary-key"), true},
+		{"unknown key", encrypt(msg, "unknown-key"), false},
+		{"not json", encrypt("hello", "primary-key"), false},
+		{"not base64", "%%%", false},
+		{"too short", "YWJj", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := decryptPush(tt.enc, keys)
+		if ok != tt.ok {
+			t.Errorf("%s: decryptPush ok = %v, want %v", tt.name, ok, tt.ok)
+			continue
+		}
+		if ok && got != msg {
+			t.Errorf("%s: decryptPush = %q, want %q", tt.name, got, msg)
+		}
+	}
+}
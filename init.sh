@@ -9,4 +9,4 @@ if [ "$UPDATE" = "1" ];then
 fi
 touch /data/install.lock
 chmod +x /data/bot_app
-/data/bot_app
\ No newline at end of file
+exec /data/bot_app
\ No newline at end of file
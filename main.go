@@ -2,65 +2,116 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/kataras/iris/v12"
 	"github.com/scjtqs2/bot_adapter/client"
-	"github.com/scjtqs2/bot_adapter/sha256"
 	log "github.com/sirupsen/logrus"
-	"github.com/tidwall/gjson"
 
 	"github.com/scjtqs2/bot_app_chat/bot"
 )
 
 func main() {
-	setup()
+	app := setup()
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, os.Interrupt)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
-	os.Exit(1)
+	shutdown(app)
 }
 
+// Version 构建版本，由 Dockerfile 通过 -X main.Version 注入
+var Version = "dev"
+
 var (
-	appID            = os.Getenv("APP_ID")
-	appSecret        = os.Getenv("APP_SECRET")
-	appEncryptKey    = os.Getenv("APP_ENCRYPT_KEY")
-	botAdapterAddr   = os.Getenv("ADAPTER_ADDR")
 	botAdapterClient *client.AdapterService
+	// appCtx 处理推送使用的 context，关闭时等待超时后取消，正在进行的 AI 请求随之结束
+	appCtx, appCancel = context.WithCancel(context.Background())
+	// configStop 关闭时停止监听配置文件
+	configStop = make(chan struct{})
 )
 
-func setup() {
-	var err error
-	botAdapterClient, err = client.NewAdapterServiceClient(botAdapterAddr, appID, appSecret)
+func setup() *iris.Application {
+	path := bot.ConfigFile()
+	conf, err := bot.LoadConfig(path)
+	if err != nil {
+		log.Fatalf("faild to load config err:%v", err)
+	}
+	bot.SetConfig(conf)
+	if err := bot.OpenMsgLog(conf.App.DBPath); err != nil {
+		log.Fatalf("faild to open msgdb err:%v", err)
+	}
+	watchConfig(path)
+	pushDispatcher = newDispatcher(conf.Worker.Count)
+	botAdapterClient, err = client.NewAdapterServiceClient(conf.App.AdapterAddr, conf.App.ID, conf.App.Secret)
 	if err != nil {
 		log.Fatalf("faild to init grpc client err:%v", err)
 	}
 	app := iris.New()
 	app.Post("/", msginput)
+	app.Get("/healthz", healthz)
+	app.Get("/readyz", readyz)
+	app.Get("/metrics", metricsHandler)
+	app.Get("/audit", auditHandler)
 	go func() {
-		port := "8080"
-		if os.Getenv("HTTP_PORT") != "" {
-			port = os.Getenv("HTTP_PORT")
-		}
-		err = app.Run(iris.Addr(":" + port))
+		port := conf.App.HTTPPort
+		err := app.Run(iris.Addr(":"+port), iris.WithoutServerError(iris.ErrServerClosed))
 		if err != nil {
 			log.Fatalf("error init http listen port %s err:%v", port, err)
 		}
 	}()
+	return app
 }
 
-func msginput(ctx iris.Context) {
-	raw, _ := ctx.GetBody()
-	enc := gjson.ParseBytes(raw).Get("encrypt").String()
-	// 解密推送数据
-	msg, err := sha256.Decrypt(enc, appEncryptKey)
-	if err != nil {
-		log.Errorf("解密失败：enc:%s err:%s", enc, err.Error())
+// shutdown 停止接收推送，等待处理中的推送完成后关闭数据库
+// 超过 shutdown_timeout 仍未完成时取消正在进行的 AI 请求
+func shutdown(app *iris.Application) {
+	timeout := time.Duration(bot.Conf().ShutdownTimeout) * time.Second
+	log.Infof("开始关闭，最多等待 %s", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	close(configStop)
+	if err := app.Shutdown(ctx); err != nil {
+		log.Errorf("关闭 http 服务失败 err:%v", err)
+	}
+	if err := pushDispatcher.drain(ctx); err != nil {
+		log.Warnf("等待处理中的推送超时，取消剩余的请求 err:%v", err)
+		appCancel()
+		// 取消后的请求还需要保存历史消息和发送已生成的回复
+		waitCtx, waitCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err := pushDispatcher.drain(waitCtx); err != nil {
+			log.Errorf("仍有推送未处理完成 err:%v", err)
+		}
+		waitCancel()
+	}
+	appCancel()
+	if err := bot.Close(ctx); err != nil {
+		log.Errorf("关闭数据库失败 err:%v", err)
 	}
-	go parseMsg(msg)
-	_ = ctx.JSON(bot.MSG{
-		"code": 200,
-		"msg":  "received",
-	})
+	log.Info("已关闭")
+}
+
+// watchConfig 配置文件修改或收到 SIGHUP 时重新加载配置
+func watchConfig(path string) {
+	onReload := func(old, conf *bot.Config) {
+		if old.App.AdapterAddr != conf.App.AdapterAddr || old.App.ID != conf.App.ID ||
+			old.App.Secret != conf.App.Secret || old.App.HTTPPort != conf.App.HTTPPort {
+			log.Warn("app 配置中的 adapter_addr、id、secret、http_port 需要重启后才能生效")
+		}
+		if old.Worker.Count != conf.Worker.Count {
+			log.Warn("worker.count 需要重启后才能生效")
+		}
+	}
+	go bot.WatchConfig(path, 5*time.Second, configStop, onReload)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Infof("收到 SIGHUP，重新加载配置文件 %s", path)
+			bot.ReloadConfig(path, onReload)
+		}
+	}()
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/kataras/iris/v12"
+	"github.com/scjtqs2/bot_adapter/pb/entity"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// 监控指标
+var (
+	eventsTotal       = bot.NewCounter("bot_events_total", "收到的推送数", "post_type")
+	providerCalls     = bot.NewCounter("bot_provider_calls_total", "AI 提供者的调用次数", "provider", "result")
+	providerLatency   = bot.NewHistogram("bot_provider_latency_seconds", "AI 提供者的调用耗时", []float64{0.5, 1, 2, 5, 10, 20, 30, 60, 120, 300}, "provider")
+	providerFallbacks = bot.NewCounter("bot_provider_fallback_total", "提供者失败后切换到下一个提供者的次数", "provider")
+	smsSends          = bot.NewCounter("bot_sms_sends_total", "短信发送次数", "result")
+	pushRejected      = bot.NewCounter("bot_push_rejected_total", "被拒绝的推送数", "reason")
+	tokensTotal       = bot.NewCounter("bot_tokens_total", "AI 提供者返回的 token 用量", "provider", "type")
+	costTotal         = bot.NewCounter("bot_cost_total", "按价格表估算的费用", "provider")
+	moderationHits    = bot.NewCounter("bot_moderation_hits_total", "触发内容审核的次数", "direction")
+	rateLimited       = bot.NewCounter("bot_rate_limited_total", "被限流的请求数，provider 为 * 表示默认限流", "provider", "scope")
+)
+
+func init() {
+	bot.NewGaugeFunc("bot_queue_depth", "等待和正在处理的推送数", func() float64 {
+		if pushDispatcher == nil {
+			return 0
+		}
+		return float64(pushDispatcher.depth())
+	})
+	bot.NewInfo("bot_build_info", "构建版本", map[string]string{"version": Version})
+}
+
+// resultLabel 将错误转成指标的 result 标签
+func resultLabel(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// healthz 进程存活检查
+func healthz(ctx iris.Context) {
+	_ = ctx.JSON(bot.MSG{
+		"code":    200,
+		"msg":     "ok",
+		"version": Version,
+	})
+}
+
+// readyz 检查数据库和 bot_adapter 的 grpc 连接是否可用
+func readyz(ctx iris.Context) {
+	checks := bot.MSG{}
+	ready := true
+	if err := bot.Msglog.Ping(); err != nil {
+		checks["leveldb"] = err.Error()
+		ready = false
+	} else {
+		checks["leveldb"] = "ok"
+	}
+	c, cancel := context.WithTimeout(ctx.Request().Context(), 3*time.Second)
+	defer cancel()
+	if _, err := botAdapterClient.GetStatus(c, &entity.GetStatusReq{}); err != nil {
+		checks["adapter"] = err.Error()
+		ready = false
+	} else {
+		checks["adapter"] = "ok"
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	ctx.StatusCode(status)
+	_ = ctx.JSON(bot.MSG{
+		"code":   status,
+		"checks": checks,
+	})
+}
+
+// metricsHandler 以 Prometheus 文本格式输出监控指标
+func metricsHandler(ctx iris.Context) {
+	ctx.ContentType("text/plain; version=0.0.4; charset=utf-8")
+	bot.WriteMetrics(ctx.ResponseWriter())
+}
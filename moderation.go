@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// moderateInput 审核提问，需要拒绝时回复提示并返回 false，mask 时替换对话中的消息
+func moderateInput(ctx context.Context, conv *bot.Conversation) bool {
+	res := bot.ModerateInput(ctx, conv.Message)
+	if !res.Flagged {
+		return true
+	}
+	moderationHits.Inc("input")
+	log.Infof("提问触发审核 group:%d user:%d reasons:%v", conv.GroupID, conv.UserID, res.Reasons)
+	if res.Notify {
+		notifyModeration(ctx, conv, "提问", conv.Message, res)
+	}
+	if res.Refuse {
+		if !conv.ChimeIn {
+			sendText(ctx, conv, bot.Conf().Moderation.RefuseReply)
+		}
+		return false
+	}
+	conv.Message = res.Text
+	return true
+}
+
+// outputFilter 审核一次回答的输出，流式输出时每段都按已经生成的全文审核，跨段的关键词也能命中
+type outputFilter struct {
+	text    string // 已经生成的全文
+	sent    int    // 已经发送的字数
+	masked  string // mask 后的全文
+	flagged bool
+	refused bool // 已经拒绝，后续的段落不再发送
+}
+
+// next 审核新生成的一段，返回实际发送的内容，已经拒绝过时返回 false
+func (f *outputFilter) next(ctx context.Context, conv *bot.Conversation, chunk string) (string, bool) {
+	if f.refused {
+		return "", false
+	}
+	if f.text != "" {
+		// 流式输出的段落去掉了首尾空白，按换行拼接成全文
+		f.text += "\n"
+		f.sent++
+	}
+	f.text += chunk
+	res := bot.ModerateOutput(ctx, f.text)
+	if !res.Flagged {
+		f.sent = utf8.RuneCountInString(f.text)
+		f.masked = f.text
+		return chunk, true
+	}
+	if !f.flagged {
+		f.flagged = true
+		moderationHits.Inc("output")
+		log.Infof("回复触发审核 group:%d user:%d reasons:%v", conv.GroupID, conv.UserID, res.Reasons)
+		if res.Notify {
+			notifyModeration(ctx, conv, "回复", f.text, res)
+		}
+	}
+	if res.Refuse {
+		f.refused = true
+		if conv.ChimeIn {
+			// 没有人提问，不需要告诉群里回复被拒绝了
+			return "", false
+		}
+		return bot.Conf().Moderation.RefuseReply, true
+	}
+	// mask 不改变字数，之前的段落已经发出，只发送新的部分
+	masked := []rune(res.Text)
+	out := string(masked[min(f.sent, len(masked)):])
+	f.sent = len(masked)
+	f.masked = res.Text
+	return out, true
+}
+
+// send 审核后发送一段回复
+func (f *outputFilter) send(ctx context.Context, conv *bot.Conversation, text string, mention bool) {
+	if text, ok := f.next(ctx, conv, text); ok {
+		post(ctx, conv, text, mention)
+	}
+}
+
+// saveHistory 回复触发审核时将历史中的回答替换为实际发送的内容，避免原文进入后续的上下文
+func (f *outputFilter) saveHistory(conv *bot.Conversation) {
+	if !f.flagged {
+		return
+	}
+	text := f.masked
+	if f.refused {
+		text = bot.Conf().Moderation.RefuseReply
+	}
+	bot.Msglog.ReplaceLastReply(conv.GroupID, conv.UserID, text)
+}
+
+// notifyModeration 私聊通知超级管理员，通知不经过审核
+func notifyModeration(ctx context.Context, conv *bot.Conversation, kind, text string, res bot.ModerationResult) {
+	where := fmt.Sprintf("私聊 %d", conv.UserID)
+	if conv.IsGroup {
+		where = fmt.Sprintf("群 %d 的 %d", conv.GroupID, conv.UserID)
+	}
+	msg := fmt.Sprintf("%s的%s触发审核：%s\n%s", where, kind, strings.Join(res.Reasons, "、"), text)
+	for _, id := range bot.Superusers() {
+		deliver(ctx, &bot.Conversation{UserID: id}, msg)
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/scjtqs2/bot_adapter/coolq"
 	"github.com/scjtqs2/bot_adapter/event"
@@ -15,57 +20,54 @@ import (
 	"github.com/scjtqs2/bot_app_chat/bot"
 )
 
-func parseMsg(data string) {
+func parseMsg(ctx context.Context, data string) {
 	msg := gjson.Parse(data)
+	if id := eventID(msg); id != "" && bot.Seen(id, time.Duration(bot.Conf().DedupTTL)*time.Second) {
+		log.Infof("重复的推送，已忽略 %s", id)
+		return
+	}
 	switch msg.Get("post_type").String() {
 	case "message": // 消息事件
 		switch msg.Get("message_type").String() {
 		case event.MessageTypePrivate:
 			var req event.MessagePrivate
 			_ = json.Unmarshal([]byte(msg.Raw), &req)
+			if !accessAllowed(ctx, 0, req.UserID) {
+				log.Debugf("不响应私聊 user=%d", req.UserID)
+				return
+			}
 			// 首先检查消息是否属于短信发送流程
 			if handlePrivateSmsConversation(req) {
 				return // 消息已被短信流程处理，直接返回
 			}
 
 			// 如果未被短信流程处理，则继续执行 AI 聊天逻辑
-			ok := false
-			if bot.OpenaiEndpoint != "" && bot.OpenaiAPIKey != "" {
-				ok = chatgpt(req.RawMessage, req.UserID, 0, false, req.SelfID)
-			}
-			if bot.GeminiEndpoint != "" && bot.GeminiAPIKey != "" && !ok {
-				ok = geminiText(req.RawMessage, req.UserID, 0, false, req.SelfID)
-			}
-			if bot.LmStudioEndpoint != "" && bot.LmStudioModel != "" && !ok {
-				ok = lmStudioChat(req.RawMessage, req.UserID, 0, false, req.SelfID)
-			}
-			if bot.TulingKey != "" && !ok {
-				ok = tuling(req.RawMessage, req.UserID, 0, false, req.SelfID)
-			}
-			if !ok {
-				ok = qingyunke(req.RawMessage, req.UserID, 0, false, req.SelfID)
-			}
+			ok := chat(ctx, &bot.Conversation{
+				Message:   req.RawMessage,
+				UserID:    req.UserID,
+				SelfID:    req.SelfID,
+				MessageID: req.MessageID,
+				Client:    botAdapterClient,
+			}, "")
 			log.Debug(ok)
 		case event.MessageTypeGroup:
 			var req event.MessageGroup
 			_ = json.Unmarshal([]byte(msg.Raw), &req)
-			ok := false
 			log.Debugf("raw:%+v ,req=%+v \n", msg.Raw, req)
-			if bot.OpenaiEndpoint != "" && bot.OpenaiAPIKey != "" {
-				ok = chatgpt(req.RawMessage, req.Sender.UserID, req.GroupID, true, req.SelfID)
-			}
-			if bot.GeminiEndpoint != "" && bot.GeminiAPIKey != "" && !ok {
-				ok = geminiText(req.RawMessage, req.Sender.UserID, req.GroupID, true, req.SelfID)
-			}
-			if bot.LmStudioEndpoint != "" && bot.LmStudioModel != "" && !ok {
-				ok = lmStudioChat(req.RawMessage, req.Sender.UserID, req.GroupID, true, req.SelfID)
-			}
-			if bot.TulingKey != "" && !ok {
-				ok = tuling(req.RawMessage, req.Sender.UserID, req.GroupID, true, req.SelfID)
-			}
-			if !ok {
-				ok = qingyunke(req.RawMessage, req.Sender.UserID, req.GroupID, true, req.SelfID)
+			if !accessAllowed(ctx, req.GroupID, req.Sender.UserID) {
+				log.Debugf("不响应群消息 group=%d user=%d", req.GroupID, req.Sender.UserID)
+				return
 			}
+			ok := chat(ctx, &bot.Conversation{
+				Message:   req.RawMessage,
+				UserID:    req.Sender.UserID,
+				GroupID:   req.GroupID,
+				SelfID:    req.SelfID,
+				IsGroup:   true,
+				MessageID: req.MessageID,
+				Sender:    senderName(req.Sender),
+				Client:    botAdapterClient,
+			}, req.Sender.Role)
 			log.Debug(ok)
 		}
 	case "notice": // 通知事件
@@ -73,6 +75,7 @@ func parseMsg(data string) {
 		case event.NOTICE_TYPE_FRIEND_ADD:
 			var req event.NoticeFriendAdd
 			_ = json.Unmarshal([]byte(msg.Raw), &req)
+			friends.invalidate()
 		case event.NOTICE_TYPE_FRIEND_RECALL:
 			var req event.NoticeFriendRecall
 			_ = json.Unmarshal([]byte(msg.Raw), &req)
@@ -127,230 +130,298 @@ func parseMsg(data string) {
 	}
 }
 
-// tuling 图灵机器人聊天
-func tuling(message string, userID int64, groupID int64, isGroup bool, bootID int64) bool {
-	if !isGroup {
-		// 私聊
-		text, err := bot.TulingText(message, userID, groupID)
-		if err != nil {
-			log.Errorf("tuling msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
-			UserId:  userID,
-			Message: []byte(text),
-		})
-		return true
+// eventID 推送的去重标识，消息按 self_id+message_id，通知和请求按内容的哈希，元事件不去重
+func eventID(msg gjson.Result) string {
+	switch msg.Get("post_type").String() {
+	case "message":
+		return fmt.Sprintf("msg/%d/%d", msg.Get("self_id").Int(), msg.Get("message_id").Int())
+	case "notice", "request":
+		sum := sha256.Sum256([]byte(msg.Raw))
+		return fmt.Sprintf("event/%d/%x", msg.Get("self_id").Int(), sum[:16])
 	}
-	var msg string
+	return ""
+}
 
-	if strings.HasPrefix(message, "#") {
-		msg = strings.Replace(message, "#", "", 1)
+// replyCode 消息中引用回复的 CQ 码
+var replyCode = regexp.MustCompile(`\[CQ:reply,id=(-?\d+)[^\]]*\]`)
+
+// quotedMaxRunes 引用的原文最多保留的字数
+const quotedMaxRunes = 500
+
+// quotedBotReply 消息引用了机器人在群里发出的消息时，返回去掉引用 CQ 码的消息和被引用的原文
+func quotedBotReply(message string, groupID int64) (string, string, bool) {
+	m := replyCode.FindStringSubmatch(message)
+	if m == nil {
+		return message, "", false
 	}
-	if ok, _ := coolq.IsAtMe(message, bootID); ok {
-		msg = strings.ReplaceAll(message, coolq.EnAtCode(fmt.Sprintf("%d", bootID)), "")
+	id, _ := strconv.ParseInt(m[1], 10, 64)
+	quoted, ok := bot.SentText(groupID, id)
+	if !ok {
+		return message, "", false
 	}
-	if msg != "" {
-		text, err := bot.TulingText(msg, userID, groupID)
-		if err != nil {
-			log.Errorf("tuling msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{
-			GroupId: groupID,
-			Message: []byte(fmt.Sprintf("%s%s", coolq.EnAtCode(fmt.Sprintf("%d", userID)), text)),
-		})
-		return true
+	if r := []rune(quoted); len(r) > quotedMaxRunes {
+		quoted = string(r[:quotedMaxRunes]) + "…"
 	}
-	return false
+	return strings.Replace(message, m[0], "", 1), quoted, true
 }
 
-// qingyunke 青云客机器人聊天
-func qingyunke(message string, userID int64, groupID int64, isGroup bool, bootID int64) bool {
-	if !isGroup {
-		// 私聊
-		text, err := bot.QingyunkeText(message, userID, groupID)
-		if err != nil {
-			log.Errorf("qingyunke msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
-			UserId:  userID,
-			Message: []byte(text),
-		})
-		return true
+// groupTrigger 判断群消息是否触发机器人，返回去掉触发标记后的消息
+// @ 机器人和引用机器人的消息总是会触发，其他触发方式由群的触发规则决定，不包括随机插话
+// 引用机器人的消息时，被引用的原文会加在消息前面
+func groupTrigger(message string, selfID, groupID int64) (string, bool) {
+	message, quoted, triggered := quotedBotReply(message, groupID)
+	if ok, _ := coolq.IsAtMe(message, selfID); ok {
+		message = strings.ReplaceAll(message, coolq.EnAtCode(fmt.Sprintf("%d", selfID)), "")
+		triggered = true
 	}
-	var msg string
-
-	if strings.HasPrefix(message, "#") {
-		msg = strings.Replace(message, "#", "", 1)
+	message = strings.TrimSpace(message)
+	rule, _ := bot.GetGroupTrigger(groupID)
+	if msg, ok := rule.Match(message); ok {
+		message = msg
+		triggered = true
 	}
-	if ok, _ := coolq.IsAtMe(message, bootID); ok {
-		msg = strings.ReplaceAll(message, coolq.EnAtCode(fmt.Sprintf("%d", bootID)), "")
+	if !triggered || message == "" {
+		return "", false
 	}
-	if msg != "" {
-		text, err := bot.QingyunkeText(msg, userID, groupID)
-		if err != nil {
-			log.Errorf("qingyunke msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{
-			GroupId: groupID,
-			Message: []byte(fmt.Sprintf("%s%s", coolq.EnAtCode(fmt.Sprintf("%d", userID)), text)),
-		})
-		return true
+	if quoted != "" {
+		message = fmt.Sprintf("（引用了你之前的回复：「%s」）\n%s", quoted, message)
 	}
-	return false
+	return message, true
 }
 
-// chatgpt chatgpt聊天
-func chatgpt(message string, userID int64, groupID int64, isGroup bool, bootID int64) bool {
-	if !isGroup {
-		// 私聊
-		text, err := bot.ChatGptText(message, userID, groupID, botAdapterClient)
-		if err != nil {
-			log.Errorf("chatgpt msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
-			UserId:  userID,
-			Message: []byte(text),
-		})
-		return true
+// senderName 发送者的群名片，没有群名片时使用昵称，都为空时使用 QQ 号
+func senderName(sender *event.MessageSender) string {
+	switch {
+	case sender == nil:
+		return ""
+	case sender.Card != "":
+		return sender.Card
+	case sender.NickName != "":
+		return sender.NickName
 	}
-	var msg string
+	return strconv.FormatInt(sender.UserID, 10)
+}
 
-	if strings.HasPrefix(message, "#") {
-		msg = strings.Replace(message, "#", "", 1)
+// recordGroupLog 开启了群聊记录的群里保存没有触发机器人的文字消息
+func recordGroupLog(conv *bot.Conversation) {
+	if !bot.GroupLogEnabled(conv.GroupID) {
+		return
 	}
-	if ok, _ := coolq.IsAtMe(message, bootID); ok {
-		msg = strings.ReplaceAll(message, coolq.EnAtCode(fmt.Sprintf("%d", bootID)), "")
+	text := strings.TrimSpace(coolq.CleanCQCode(conv.Message))
+	if text == "" {
+		return
 	}
-	if msg != "" {
-		text, err := bot.ChatGptText(msg, userID, groupID, botAdapterClient)
-		if err != nil {
-			log.Errorf("chatgpt msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{
-			GroupId: groupID,
-			Message: []byte(fmt.Sprintf("%s%s", coolq.EnAtCode(fmt.Sprintf("%d", userID)), text)),
-		})
-		return true
+	bot.AppendGroupLog(conv.GroupID, bot.GroupLogEntry{
+		Time:   time.Now().Unix(),
+		UserID: conv.UserID,
+		Sender: conv.Sender,
+		Text:   text,
+	})
+}
+
+// chimeIn 未触发的群消息按群的插话概率决定是否回复，只有图片等内容的消息不插话
+func chimeIn(conv *bot.Conversation) bool {
+	if coolq.CleanCQCode(conv.Message) == "" {
+		return false
 	}
-	return false
+	rule, _ := bot.GetGroupTrigger(conv.GroupID)
+	return rule.ChimeIn()
 }
 
-// geminiText gemini聊天
-func geminiText(message string, userID int64, groupID int64, isGroup bool, bootID int64) bool {
-	if !isGroup {
-		// 私聊
-		text, err := bot.GeminiText(message, userID, groupID, botAdapterClient)
-		if err != nil {
-			if err.Error() == "empty" {
-				log.Infof("gemini msg info:%v", err)
-			} else {
-				log.Errorf("gemini msg error:%v", err)
-			}
+// chat 处理聊天命令，或者将触发的消息交给 AI 回复
+func chat(ctx context.Context, conv *bot.Conversation, role string) bool {
+	if handleCommand(ctx, conv, role) {
+		return true
+	}
+	if conv.IsGroup {
+		if !bot.GroupEnabled(conv.GroupID) {
 			return false
 		}
-		if text == "" {
-			return false
+		msg, ok := groupTrigger(conv.Message, conv.SelfID, conv.GroupID)
+		if !ok {
+			if !chimeIn(conv) {
+				recordGroupLog(conv)
+				return false
+			}
+			msg = strings.TrimSpace(conv.Message)
+			conv.ChimeIn = true
 		}
-		_, _ = botAdapterClient.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
-			UserId:  userID,
-			Message: []byte(text),
-		})
+		conv.Message = msg
+		if bot.GroupSharedContext(conv.GroupID) && coolq.CleanCQCode(msg) != "" {
+			// 共享上下文时标注发言人，让模型知道每句话是谁说的
+			conv.Message = fmt.Sprintf("%s：%s", conv.Sender, msg)
+		}
+		bot.IncrStat(fmt.Sprintf("group/%d/messages", conv.GroupID))
+	} else {
+		bot.IncrStat("private/messages")
+	}
+	exempt := rateLimitExempt(conv, bot.UserRole(conv, role))
+	if !exempt && !allowRequest(conv, "") {
+		throttled(ctx, conv)
 		return true
 	}
-	var msg string
+	return reply(ctx, conv, exempt)
+}
 
-	if strings.HasPrefix(message, "#") {
-		msg = strings.Replace(message, "#", "", 1)
+// reply 按路由依次尝试已启用的 AI 提供者，直到有一个成功回复
+// exempt 为 false 时检查每个提供者的限流，超限的提供者会被跳过
+func reply(ctx context.Context, conv *bot.Conversation, exempt bool) bool {
+	if !moderateInput(ctx, conv) {
+		return true
 	}
-	if ok, _ := coolq.IsAtMe(message, bootID); ok {
-		msg = strings.ReplaceAll(message, coolq.EnAtCode(fmt.Sprintf("%d", bootID)), "")
+	hasText := coolq.CleanCQCode(conv.Message) != ""
+	// 流式输出的第一段 @ 提问者，后续段落直接发送
+	streamed := 0
+	out := &outputFilter{}
+	stream := func(text string) {
+		out.send(ctx, conv, text, streamed == 0)
+		streamed++
 	}
-	if msg != "" {
-		text, err := bot.GeminiText(msg, userID, groupID, botAdapterClient)
+	limited, skipped := false, false
+	var quota bot.QuotaUsage
+	for _, p := range bot.Route(conv.GroupID, conv.UserID) {
+		if !p.Enabled() {
+			continue
+		}
+		if !hasText && !p.Capabilities().Has(bot.CapImage) {
+			log.Debugf("%s 不支持图片消息，跳过", p.Name())
+			continue
+		}
+		if q, exhausted := bot.BudgetExhausted(conv.GroupID, conv.UserID, p.Name()); exhausted && (q.IsProvider() || !bot.BudgetFallback(p.Name())) {
+			// 提供者自己的额度用完时跳过，其他额度用完后降级到链路中不计费的提供者
+			quota, skipped = q, true
+			continue
+		}
+		if !exempt && !allowRequest(conv, p.Name()) {
+			limited = true
+			continue
+		}
+		// 只有支持流式输出的提供者才会拿到发送段落的回调
+		conv.Stream = nil
+		if p.Capabilities().Has(bot.CapStream) {
+			conv.Stream = stream
+		}
+		start := time.Now()
+		text, err := bot.CallProvider(ctx, p, conv)
+		providerLatency.Observe(time.Since(start).Seconds(), p.Name())
+		providerCalls.Inc(p.Name(), resultLabel(err))
+		if u := conv.Usage(); u.PromptTokens+u.CompletionTokens > 0 {
+			tokensTotal.Add(float64(u.PromptTokens), p.Name(), "prompt")
+			tokensTotal.Add(float64(u.CompletionTokens), p.Name(), "completion")
+			costTotal.Add(u.Cost(), p.Name())
+		}
+		bot.IncrStat("provider/" + p.Name() + "/" + resultLabel(err))
+		if streamed > 0 {
+			// 已经发出部分内容，不再切换到其他提供者
+			if err != nil {
+				log.Errorf("%s stream error after %d chunks:%v", p.Name(), streamed, err)
+			} else {
+				out.saveHistory(conv)
+				answered(conv, p)
+			}
+			return true
+		}
 		if err != nil {
-			if err.Error() == "empty" {
-				log.Infof("gemini msg info:%v", err)
+			if errors.Is(err, bot.ErrEmpty) {
+				log.Infof("%s msg info:%v", p.Name(), err)
 			} else {
-				log.Errorf("gemini msg error:%v", err)
+				log.Errorf("%s msg error:%v", p.Name(), err)
 			}
-			return false
+			providerFallbacks.Inc(p.Name())
+			continue
 		}
 		if text == "" {
-			return false
+			providerFallbacks.Inc(p.Name())
+			continue
 		}
-		_, _ = botAdapterClient.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{
-			GroupId: groupID,
-			Message: []byte(fmt.Sprintf("%s%s", coolq.EnAtCode(fmt.Sprintf("%d", userID)), text)),
-		})
+		out.send(ctx, conv, text, true)
+		out.saveHistory(conv)
+		answered(conv, p)
+		return true
+	}
+	if skipped {
+		overBudget(ctx, conv, quota)
+		return true
+	}
+	if limited {
+		throttled(ctx, conv)
 		return true
 	}
 	return false
 }
 
-// lmstudio chatgpt聊天
-func lmStudioChat(message string, userID int64, groupID int64, isGroup bool, bootID int64) bool {
-	if !isGroup {
-		// 私聊
-		text, err := bot.LmStudioText(message, userID, groupID, botAdapterClient)
-		if err != nil {
-			log.Errorf("lmstudio msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendPrivateMsg(context.TODO(), &entity.SendPrivateMsgReq{
-			UserId:  userID,
-			Message: []byte(text),
-		})
-		return true
+// answered 回答成功后记录提问，用于 #retry 和 #undo，重新生成时删除被替换的一轮
+// 被限流、拒绝或者失败的提问没有进入历史，不会走到这里
+func answered(conv *bot.Conversation, p bot.Provider) {
+	if !p.Capabilities().Has(bot.CapHistory) {
+		// 这次问答没有保存到历史中，不能撤销或者重新生成之前的一轮
+		bot.Msglog.SetLastInput(conv.GroupID, conv.UserID, "")
+		return
+	}
+	if conv.ReplaceTurn != 0 {
+		bot.Msglog.DropTurn(conv.GroupID, conv.UserID, conv.ReplaceTurn)
 	}
-	var msg string
+	bot.Msglog.SetLastInput(conv.GroupID, conv.UserID, conv.Message)
+}
+
+// sendText 发送回复，群聊中会 @ 提问者或引用提问的消息
+func sendText(ctx context.Context, conv *bot.Conversation, text string) {
+	send(ctx, conv, text, true)
+}
 
-	if strings.HasPrefix(message, "#") {
-		msg = strings.Replace(message, "#", "", 1)
+// mentionPrefix 群聊中回复的前缀，按群的设置 @ 提问者或者引用提问的消息
+func mentionPrefix(conv *bot.Conversation) string {
+	at := coolq.EnAtCode(fmt.Sprintf("%d", conv.UserID))
+	if conv.MessageID == 0 {
+		return at
 	}
-	if ok, _ := coolq.IsAtMe(message, bootID); ok {
-		msg = strings.ReplaceAll(message, coolq.EnAtCode(fmt.Sprintf("%d", bootID)), "")
+	switch bot.GroupReplyMode(conv.GroupID) {
+	case bot.ReplyModeQuote:
+		return coolq.EnReplyCode(int(conv.MessageID))
+	case bot.ReplyModeBoth:
+		return coolq.EnReplyCode(int(conv.MessageID)) + at
 	}
-	if msg != "" {
-		text, err := bot.LmStudioText(msg, userID, groupID, botAdapterClient)
-		if err != nil {
-			log.Errorf("lmstudio msg error:%v", err)
-			return false
-		}
-		if text == "" {
-			return false
-		}
-		_, _ = botAdapterClient.SendGroupMsg(context.TODO(), &entity.SendGroupMsgReq{
-			GroupId: groupID,
-			Message: []byte(fmt.Sprintf("%s%s", coolq.EnAtCode(fmt.Sprintf("%d", userID)), text)),
+	return at
+}
+
+// send 审核后发送回复，mention 为 true 时群聊中会 @ 提问者或引用提问的消息
+func send(ctx context.Context, conv *bot.Conversation, text string, mention bool) {
+	(&outputFilter{}).send(ctx, conv, text, mention)
+}
+
+// post 发送已经审核过的回复
+func post(ctx context.Context, conv *bot.Conversation, text string, mention bool) {
+	msg := text
+	if conv.IsGroup && mention {
+		msg = mentionPrefix(conv) + text
+	}
+	if id, ok := deliver(ctx, conv, msg); ok && conv.IsGroup {
+		// 记录群里发出的消息，用户引用回复时可以触发机器人
+		bot.RecordSent(conv.GroupID, id, text)
+	}
+}
+
+// deliver 直接发送消息，不经过审核，返回消息的 message_id
+// 关闭时 ctx 会被取消，已经生成的回复仍然需要发出去
+func deliver(ctx context.Context, conv *bot.Conversation, text string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	var rsp *entity.SendMsgRsp
+	var err error
+	if conv.IsGroup {
+		rsp, err = botAdapterClient.SendGroupMsg(ctx, &entity.SendGroupMsgReq{
+			GroupId: conv.GroupID,
+			Message: []byte(text),
+		})
+	} else {
+		rsp, err = botAdapterClient.SendPrivateMsg(ctx, &entity.SendPrivateMsgReq{
+			UserId:  conv.UserID,
+			Message: []byte(text),
 		})
-		return true
 	}
-	return false
+	if err != nil {
+		log.Errorf("发送回复失败 group=%d user=%d err:%v", conv.GroupID, conv.UserID, err)
+		return 0, false
+	}
+	return rsp.GetMessageId(), true
 }
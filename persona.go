@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+func init() {
+	registerCommand(&command{
+		name:   "persona",
+		usage:  "#persona [show|list|set <名称> [temperature=0.7] [model=提供者:模型] [提示词]|del <名称>|reset] 管理人设",
+		handle: personaCommand,
+	})
+}
+
+// personaCommand 人设按群或私聊保存，群聊中只有群主和管理员可以修改
+func personaCommand(c *commandContext) {
+	sub := "show"
+	if len(c.args) > 0 {
+		sub = strings.ToLower(c.args[0])
+	}
+	conv := c.conv
+	if conv.IsGroup && sub != "show" && sub != "list" && !c.isGroupManager() {
+		c.reply("只有群主或管理员可以修改人设")
+		return
+	}
+	switch sub {
+	case "show":
+		c.reply(formatPersona(bot.ActivePersona(conv.GroupID, conv.UserID)))
+	case "list":
+		active, list := bot.ListPersonas(conv.GroupID, conv.UserID)
+		var b strings.Builder
+		b.WriteString("人设列表：")
+		for _, p := range list {
+			b.WriteString("\n")
+			if p.Name == active {
+				b.WriteString("* ")
+			} else {
+				b.WriteString("  ")
+			}
+			b.WriteString(p.Name)
+		}
+		c.reply(b.String())
+	case "set":
+		p, err := parsePersona(strings.TrimSpace(strings.TrimPrefix(c.text, c.args[0])))
+		if err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		switch {
+		case p.Prompt == "" && p.Temperature == nil && p.Model == "":
+			err = bot.UsePersona(conv.GroupID, conv.UserID, p.Name)
+		case p.Prompt == "":
+			// 只修改选项时沿用原来的提示词
+			old := bot.GetPersona(conv.GroupID, conv.UserID, p.Name)
+			if old == nil {
+				err = fmt.Errorf("人设 %s 不存在，请同时提供提示词", p.Name)
+				break
+			}
+			p.Prompt = old.Prompt
+			err = bot.SavePersona(conv.GroupID, conv.UserID, p)
+		default:
+			err = bot.SavePersona(conv.GroupID, conv.UserID, p)
+		}
+		if err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		c.replyf("已切换人设\n%s", formatPersona(bot.ActivePersona(conv.GroupID, conv.UserID)))
+	case "del":
+		if len(c.args) < 2 {
+			c.reply("请指定要删除的人设名称")
+			return
+		}
+		if err := bot.DeletePersona(conv.GroupID, conv.UserID, c.args[1]); err != nil {
+			c.replyf("删除失败：%v", err)
+			return
+		}
+		c.replyf("已删除人设 %s", c.args[1])
+	case "reset":
+		if err := bot.ResetPersona(conv.GroupID, conv.UserID); err != nil {
+			c.replyf("重置失败：%v", err)
+			return
+		}
+		c.reply("已恢复默认人设")
+	default:
+		c.reply(commands["persona"].usage)
+	}
+}
+
+// parsePersona 解析 <名称> [temperature=0.7] [model=提供者:模型] [提示词]
+func parsePersona(text string) (*bot.Persona, error) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("请指定人设名称")
+	}
+	p := &bot.Persona{Name: fields[0]}
+	rest := strings.TrimSpace(strings.TrimPrefix(text, fields[0]))
+	for _, f := range fields[1:] {
+		k, v, ok := strings.Cut(f, "=")
+		if !ok {
+			break
+		}
+		switch strings.ToLower(k) {
+		case "temperature":
+			t, err := strconv.ParseFloat(v, 64)
+			if err != nil || t < 0 || t > 2 {
+				return nil, fmt.Errorf("temperature 需要是 0 到 2 之间的数字")
+			}
+			p.Temperature = &t
+		case "model":
+			p.Model = v
+		default:
+			return nil, fmt.Errorf("未知的选项 %s", k)
+		}
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, f))
+	}
+	p.Prompt = rest
+	return p, nil
+}
+
+// formatPersona 格式化人设信息
+func formatPersona(p *bot.Persona) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "人设：%s", p.Name)
+	if p.Model != "" {
+		fmt.Fprintf(&b, "\n模型：%s", p.Model)
+	}
+	if p.Temperature != nil {
+		fmt.Fprintf(&b, "\n温度：%g", *p.Temperature)
+	}
+	fmt.Fprintf(&b, "\n提示词：%s", p.Prompt)
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+func init() {
+	registerCommand(&command{
+		name:   "quota",
+		usage:  "#quota 查看今日和本月剩余的额度",
+		handle: quotaCommand,
+	})
+}
+
+// periodName 统计周期的中文名称
+func periodName(period string) string {
+	if period == bot.PeriodMonth {
+		return "本月"
+	}
+	return "今日"
+}
+
+// scopeName 额度范围的中文名称
+func scopeName(scope string) string {
+	switch {
+	case strings.HasPrefix(scope, bot.LimitScopeUser+"/"):
+		return "你"
+	case strings.HasPrefix(scope, bot.LimitScopeGroup+"/"):
+		return "本群"
+	case strings.HasPrefix(scope, bot.UsageScopeProvider+"/"):
+		return strings.TrimPrefix(scope, bot.UsageScopeProvider+"/")
+	}
+	return "全局"
+}
+
+// quotaCommand 列出对话在各个范围的用量，没有设置额度的只显示用量
+func quotaCommand(c *commandContext) {
+	quotas := bot.Quotas(c.conv.GroupID, c.conv.UserID, "")
+	if c.role >= bot.RoleSuperuser {
+		// 超级管理员还可以看到有用量或者设置了额度的提供者
+		for _, p := range bot.Providers() {
+			for _, q := range bot.Quotas(c.conv.GroupID, c.conv.UserID, p.Name()) {
+				if q.IsProvider() && (q.Used.Tokens > 0 || q.Quota != bot.Quota{}) {
+					quotas = append(quotas, q)
+				}
+			}
+		}
+	}
+	var b strings.Builder
+	for _, q := range quotas {
+		// 全局用量只对超级管理员展示
+		if q.Scope == bot.LimitScopeGlobal && c.role < bot.RoleSuperuser {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%s%s：%s", periodName(q.Period), scopeName(q.Scope), formatQuota(q))
+	}
+	c.reply(b.String())
+}
+
+// formatQuota 格式化用量和剩余额度
+func formatQuota(q bot.QuotaUsage) string {
+	parts := []string{fmt.Sprintf("已用 %d token", q.Used.Tokens)}
+	if q.Quota.Tokens > 0 {
+		parts = append(parts, fmt.Sprintf("剩余 %d token", max(q.Quota.Tokens-q.Used.Tokens, 0)))
+	}
+	if q.Used.Cost > 0 || q.Quota.Cost > 0 {
+		parts = append(parts, fmt.Sprintf("费用 %.4f", q.Used.Cost))
+	}
+	if q.Quota.Cost > 0 {
+		parts = append(parts, fmt.Sprintf("剩余 %.4f", max(q.Quota.Cost-q.Used.Cost, 0)))
+	}
+	return strings.Join(parts, "，")
+}
+
+// overBudget 额度用完并且没有可以降级使用的提供者时回复提示
+func overBudget(ctx context.Context, conv *bot.Conversation, q bot.QuotaUsage) {
+	if conv.ChimeIn || !bot.ThrottleNotice(conv.GroupID, conv.UserID) {
+		return
+	}
+	next := "明天"
+	if q.Period == bot.PeriodMonth {
+		next = "下个月"
+	}
+	sendText(ctx, conv, fmt.Sprintf("%s%s的额度已用完，请%s再试", periodName(q.Period), scopeName(q.Scope), next))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// throttleReply 触发限流时的回复
+const throttleReply = "消息太频繁啦，请休息一下稍后再试"
+
+// rateLimitExempt 群主、群管理员和超级管理员不受限流，私聊中的用户不算管理员
+func rateLimitExempt(conv *bot.Conversation, role bot.Role) bool {
+	return role == bot.RoleSuperuser || (conv.IsGroup && role >= bot.RoleGroupAdmin)
+}
+
+// allowRequest 检查限流，provider 为空时检查默认限流，随机插话不扣除令牌
+func allowRequest(conv *bot.Conversation, provider string) bool {
+	check := bot.AllowRequest
+	if conv.ChimeIn {
+		check = bot.PeekRequest
+	}
+	scope, ok := check(provider, conv.GroupID, conv.UserID)
+	if !ok {
+		label := provider
+		if label == "" {
+			label = "*"
+		}
+		rateLimited.Inc(label, scope)
+		log.Infof("限流 provider:%s scope:%s group:%d user:%d", label, scope, conv.GroupID, conv.UserID)
+	}
+	return ok
+}
+
+// throttled 回复限流提示，提示本身也有频率限制，避免刷屏，随机插话被限流时不提示
+func throttled(ctx context.Context, conv *bot.Conversation) {
+	if !conv.ChimeIn && bot.ThrottleNotice(conv.GroupID, conv.UserID) {
+		sendText(ctx, conv, throttleReply)
+	}
+}
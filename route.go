@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+func init() {
+	registerCommand(&command{
+		name:   "route",
+		usage:  "#route [show|set <provider,...>|reset] 查看或设置提供者顺序",
+		perm:   bot.RoleGroupAdmin,
+		handle: routeCommand,
+	})
+}
+
+// routeCommand 群聊中设置群的提供者顺序，私聊中设置自己的提供者顺序
+func routeCommand(c *commandContext) {
+	sub := "show"
+	if len(c.args) > 0 {
+		sub = strings.ToLower(c.args[0])
+	}
+	conv := c.conv
+	switch sub {
+	case "show":
+		c.replyf("当前提供者顺序：%s", chainNames(bot.Route(conv.GroupID, conv.UserID)))
+	case "set":
+		chain := bot.ParseChain(strings.Join(c.args[1:], ","))
+		var err error
+		if conv.IsGroup {
+			err = bot.SetGroupRoute(conv.GroupID, chain)
+		} else {
+			err = bot.SetUserRoute(conv.UserID, chain)
+		}
+		if err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		c.replyf("已设置，当前提供者顺序：%s", chainNames(bot.Route(conv.GroupID, conv.UserID)))
+	case "reset":
+		var err error
+		if conv.IsGroup {
+			err = bot.SetGroupRoute(conv.GroupID, nil)
+		} else {
+			err = bot.SetUserRoute(conv.UserID, nil)
+		}
+		if err != nil {
+			c.replyf("重置失败：%v", err)
+			return
+		}
+		c.replyf("已恢复默认，当前提供者顺序：%s", chainNames(bot.Route(conv.GroupID, conv.UserID)))
+	default:
+		c.reply(commands["route"].usage)
+	}
+}
+
+// chainNames 拼接提供者名称，未启用的提供者会被标注
+func chainNames(chain []bot.Provider) string {
+	names := make([]string, 0, len(chain))
+	for _, p := range chain {
+		if p.Enabled() {
+			names = append(names, p.Name())
+		} else {
+			names = append(names, p.Name()+"(未启用)")
+		}
+	}
+	return strings.Join(names, " > ")
+}
@@ -9,8 +9,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +16,8 @@ import (
 	"github.com/scjtqs2/bot_adapter/event"
 	"github.com/scjtqs2/bot_adapter/pb/entity"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
 )
 
 // --- SMS 状态管理 ---
@@ -41,16 +41,10 @@ var (
 	// smsStateLock 保护 userSmsState 的并发访问
 	smsStateLock = &sync.Mutex{}
 
-	// allowedSmsUserIDs 声明，将在 init() 中被填充
-	allowedSmsUserIDs map[int64]bool
-
-	// SMS API 配置
-	smsAPIURL    string
-	smsAPISecret string
-	smsAPIClient *http.Client
-
-	// isSmsFeatureEnabled 标记短信功能是否全局启用
-	isSmsFeatureEnabled bool
+	// smsAPIClient 调用短信接口的 http client
+	smsAPIClient = &http.Client{
+		Timeout: 30 * time.Second, // 30秒超时
+	}
 )
 
 // SMSSendRequest 定义了调用 API 所需的结构体
@@ -68,67 +62,14 @@ type APIResponse struct {
 	Message string `json:"message"`
 }
 
-func init() {
-	// 检查短信功能是否全局启用
-	smsEnabledEnv := os.Getenv("SMS_FEATURE_ENABLED")
-	isSmsFeatureEnabled = (strings.ToLower(smsEnabledEnv) == "true" || smsEnabledEnv == "1")
-	log.Infof("SMS 短信功能已启用: %v", isSmsFeatureEnabled)
-
-	// 如果功能未启用，可以提前退出 init 中的 SMS 相关设置
-	if !isSmsFeatureEnabled {
-		log.Info("SMS 短信功能未启用，跳过 API 和用户配置。")
-		return
-	}
-
-	// 从环境变量加载 SMS API 配置
-	smsAPIURL = os.Getenv("SMS_API_URL")
-	if smsAPIURL == "" {
-		// 使用你提供的 URL 作为后备
-		smsAPIURL = "http://192.168.50.124:1285/api/v1/sms/send"
-		log.Warnf("SMS_API_URL 未设置, 使用默认值: %s", smsAPIURL)
-	}
-	// 这个 Secret 必须与你的 http_handler 服务中设置的 FORWARD_SECRET 环境变量一致
-	smsAPISecret = os.Getenv("SMS_API_SECRET")
-	if smsAPISecret == "" {
-		log.Warn("SMS_API_SECRET 未设置。短信发送可能会因认证失败。")
-	}
-
-	smsAPIClient = &http.Client{
-		Timeout: 30 * time.Second, // 30秒超时
-	}
-	log.Infof("SMS API Handler 初始化, URL: %s", smsAPIURL)
-
-	// 初始化 allowedSmsUserIDs map
-	allowedSmsUserIDs = make(map[int64]bool)
-
-	// 从环境变量加载授权用户ID
-	usersEnv := os.Getenv("SMS_ALLOWED_USERS")
-	if usersEnv == "" {
-		log.Warn("SMS_ALLOWED_USERS 环境变量未设置, 短信功能将对所有非管理员用户禁用。")
-		// 注意：这里没有 return，允许 init() 的其余部分继续执行
-	} else {
-		// 按逗号分割ID
-		idStrings := strings.Split(usersEnv, ",")
-		loadedCount := 0
-		for _, idStr := range idStrings {
-			trimmedID := strings.TrimSpace(idStr)
-			if trimmedID == "" {
-				continue
-			}
-
-			// 解析 ID
-			id, err := strconv.ParseInt(trimmedID, 10, 64)
-			if err != nil {
-				log.Warnf("无法解析 SMS_ALLOWED_USERS 中的 ID: '%s'，已跳过", idStr)
-				continue
-			}
-
-			// 添加到 map 中
-			allowedSmsUserIDs[id] = true
-			loadedCount++
+// smsAllowed 检查用户是否在 sms.allowed_users 或者 sms 白名单中
+func smsAllowed(userID int64) bool {
+	for _, id := range bot.Conf().SMS.AllowedUsers {
+		if id == userID {
+			return true
 		}
-		log.Infof("成功从 SMS_ALLOWED_USERS 加载 %d 个授权用户 ID", loadedCount)
 	}
+	return bot.InAllowList(bot.AllowListSMS, userID)
 }
 
 // --- SMS 核心处理逻辑 ---
@@ -137,7 +78,7 @@ func init() {
 // 返回 'true' 表示消息已被短信流程处理，'false' 表示应由 AI 继续处理
 func handlePrivateSmsConversation(req event.MessagePrivate) bool {
 	// 0. 检查功能是否全局启用
-	if !isSmsFeatureEnabled {
+	if !bot.Conf().SMS.Enabled {
 		return false // 功能未启用，交由 AI 处理
 	}
 
@@ -163,7 +104,7 @@ func handlePrivateSmsConversation(req event.MessagePrivate) bool {
 		// 3. 用户不在会话中，检查是否为 #send 启动命令
 		if strings.HasPrefix(message, "#send ") {
 			// 3.1 检查权限
-			if _, allowed := allowedSmsUserIDs[userID]; !allowed {
+			if !smsAllowed(userID) {
 				sendReply(userID, "您没有权限使用这个 bot。")
 				return true // 消息已处理（已拒绝）
 			}
@@ -226,6 +167,7 @@ func handlePrivateSmsConversation(req event.MessagePrivate) bool {
 
 			// (此处 API 调用会自动使用正确的 device)
 			result, err := sendSmsViaAPI(state.Device, state.PhoneNumber, state.Message)
+			smsSends.Inc(resultLabel(err))
 			var replyText string
 			if err != nil {
 				replyText = fmt.Sprintf("发送失败：\n%s", err.Error())
@@ -249,14 +191,15 @@ func handlePrivateSmsConversation(req event.MessagePrivate) bool {
 
 // sendSmsViaAPI 执行 HTTP POST 请求到 SMS 服务
 func sendSmsViaAPI(device, recipient, message string) (string, error) {
-	if smsAPIURL == "" {
-		return "", fmt.Errorf("SMS_API_URL 未配置")
+	conf := bot.Conf().SMS
+	if conf.APIURL == "" {
+		return "", fmt.Errorf("sms.api_url 未配置")
 	}
 
 	// 1. 构建请求体
 	reqPayload := SMSSendRequest{
-		Secret:    smsAPISecret, // 从环境变量中读取
-		Device:    device,       // 使用传入的 device
+		Secret:    conf.APISecret, // 从配置中读取
+		Device:    device,         // 使用传入的 device
 		Recipient: recipient,
 		Message:   message,
 	}
@@ -268,16 +211,16 @@ func sendSmsViaAPI(device, recipient, message string) (string, error) {
 	}
 
 	// 2. 创建 HTTP 请求
-	req, err := http.NewRequest("POST", smsAPIURL, bytes.NewBuffer(payloadBytes))
+	req, err := http.NewRequest("POST", conf.APIURL, bytes.NewBuffer(payloadBytes))
 	if err != nil {
 		log.Errorf("SMS API: 创建 HTTP 请求失败: %v", err)
 		return "", fmt.Errorf("创建 HTTP 请求失败: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Auth-Secret", smsAPISecret) // 带上secret的授权
+	req.Header.Set("X-Auth-Secret", conf.APISecret) // 带上secret的授权
 
 	// 3. 发送请求
-	log.Infof("SMS API: 正在发送请求到 %s (Device: %s)", smsAPIURL, device)
+	log.Infof("SMS API: 正在发送请求到 %s (Device: %s)", conf.APIURL, device)
 	resp, err := smsAPIClient.Do(req)
 	if err != nil {
 		log.Errorf("SMS API: 请求失败: %v", err)
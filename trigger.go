@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/scjtqs2/bot_app_chat/bot"
+)
+
+// triggerUsage #admin trigger 的详细用法
+const triggerUsage = `#admin trigger [群号] 查看群的触发规则
+#admin trigger [群号] <prefixes|nicknames|patterns> <值 ...|-> 设置前缀、昵称或正则，多个值用空格分隔，- 表示清空
+#admin trigger [群号] probability <0-1> 设置随机插话的概率
+#admin trigger [群号] reset 恢复默认规则`
+
+// adminTrigger 查看或修改群的触发规则
+func adminTrigger(c *commandContext, args []string) {
+	groupID, args, ok := c.adminGroup("trigger", args)
+	if !ok {
+		return
+	}
+	rule, custom := bot.GetGroupTrigger(groupID)
+	if len(args) == 0 {
+		c.reply(formatTrigger(groupID, rule, custom))
+		return
+	}
+	field := strings.ToLower(args[0])
+	values := args[1:]
+	if len(values) == 1 && values[0] == "-" {
+		values = nil
+	}
+	switch {
+	case field == "reset":
+		if err := bot.SetGroupTrigger(groupID, nil); err != nil {
+			c.replyf("设置失败：%v", err)
+			return
+		}
+		rule, custom = bot.GetGroupTrigger(groupID)
+		c.reply(formatTrigger(groupID, rule, custom))
+		return
+	case len(args) < 2:
+		c.reply(triggerUsage)
+		return
+	case field == "prefixes":
+		rule.Prefixes = values
+	case field == "nicknames":
+		rule.Nicknames = values
+	case field == "patterns":
+		rule.Patterns = values
+	case field == "probability":
+		p, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			c.replyf("无效的概率：%s", args[1])
+			return
+		}
+		rule.Probability = p
+	default:
+		c.reply(triggerUsage)
+		return
+	}
+	if err := bot.SetGroupTrigger(groupID, &rule); err != nil {
+		c.replyf("设置失败：%v", err)
+		return
+	}
+	c.reply(formatTrigger(groupID, rule, true))
+}
+
+// formatTrigger 格式化群的触发规则
+func formatTrigger(groupID int64, rule bot.TriggerRule, custom bool) string {
+	list := func(values []string) string {
+		if len(values) == 0 {
+			return "无"
+		}
+		return strings.Join(values, " ")
+	}
+	source := "默认"
+	if custom {
+		source = "本群单独设置"
+	}
+	return fmt.Sprintf("群 %d 的触发规则（%s）：\n@机器人\n前缀：%s\n昵称：%s\n正则：%s\n插话概率：%g",
+		groupID, source, list(rule.Prefixes), list(rule.Nicknames), list(rule.Patterns), rule.Probability)
+}